@@ -0,0 +1,144 @@
+package main
+
+import (
+    "time"
+    "errors"
+    "context"
+    
+    log "github.com/Sirupsen/logrus"
+    
+    "github.com/bluestatedigital/centralbooking/client"
+    "github.com/bluestatedigital/centralbooking/bootstrap"
+)
+
+// registers the instance it runs on and writes out its credentials
+type BootstrapCommand struct {
+    Endpoint         string `env:"CENTRALBOOKING_ADDR" long:"endpoint" description:"base URL of centralbooking" required:"true"`
+    MetadataEndpoint string `long:"metadata-endpoint" description:"base URL of the EC2 metadata service" default:"http://169.254.169.254/latest"`
+    Timeout          time.Duration `long:"timeout" description:"give up registering after this long" default:"5m"`
+    
+    Environment string   `long:"environment" description:"environment the instance belongs to" required:"true"`
+    Provider    string   `long:"provider"    description:"provider the instance runs on" default:"aws"`
+    Account     string   `long:"account"     description:"account name; defaults to the account ID from the identity document, which is only accepted if aws.accounts is empty"`
+    Role        string   `long:"role"        description:"role of the instance" required:"true"`
+    Policies    []string `long:"policy"      description:"policy to request; may be repeated"`
+    
+    TokenFile    string `long:"token-file"     description:"file to write the perm token to"`
+    RoleIDFile   string `long:"role-id-file"   description:"file to write the AppRole role_id to, in approle mode"`
+    SecretIDFile string `long:"secret-id-file" description:"file to write the AppRole secret_id to, in approle mode"`
+    
+    ConsulConfigFile string `long:"consul-config" description:"file to write Consul agent config joining the Consul servers to"`
+    ConsulACL        bool   `long:"consul-acl"    description:"include the role's Consul ACL token in the Consul agent config"`
+}
+
+func (self *BootstrapCommand) run() error {
+    // the registration mode isn't known until we've registered, which can't
+    // be undone
+    if self.TokenFile == "" && self.RoleIDFile == "" {
+        return errors.New("at least one of --token-file and --role-id-file is required")
+    }
+    
+    if (self.RoleIDFile == "") != (self.SecretIDFile == "") {
+        return errors.New("--role-id-file and --secret-id-file go together")
+    }
+    
+    if self.ConsulACL && self.ConsulConfigFile == "" {
+        return errors.New("--consul-acl requires --consul-config")
+    }
+    
+    ctx, cancel := context.WithTimeout(context.Background(), self.Timeout)
+    defer cancel()
+    
+    log.Debugf("retrieving identity from %s", self.MetadataEndpoint)
+    identity, err := bootstrap.GetIdentity(ctx, nil, self.MetadataEndpoint)
+    if err != nil {
+        return err
+    }
+    
+    account := self.Account
+    if account == "" {
+        account = identity.AccountID
+    }
+    
+    c := client.NewClient(self.Endpoint, nil)
+    
+    // cubbyhole entries are read once the credentials are safely written;
+    // they can't be read again if reading an entry fails
+    log.Infof("registering %s with %s", identity.InstanceID, self.Endpoint)
+    resp, err := c.Register(ctx, &client.RegisterRequest{
+        Environment: self.Environment,
+        Provider:    self.Provider,
+        Account:     account,
+        Region:      identity.Region,
+        InstanceID:  identity.InstanceID,
+        Role:        self.Role,
+        Policies:    self.Policies,
+        
+        IdentityDocument:  identity.Document,
+        IdentitySignature: identity.Signature,
+        
+        Exchange: true,
+    })
+    
+    if err != nil {
+        return err
+    }
+    
+    creds := resp.Credentials
+    if resp.RegistrationMode == "approle" {
+        if self.RoleIDFile == "" {
+            return errors.New("registered in approle mode but no --role-id-file given")
+        }
+        
+        log.Infof("writing role_id to %s", self.RoleIDFile)
+        err = bootstrap.WriteFile(self.RoleIDFile, []byte(creds.RoleID), 0600)
+        if err != nil {
+            return err
+        }
+        
+        log.Infof("writing secret_id to %s", self.SecretIDFile)
+        err = bootstrap.WriteFile(self.SecretIDFile, []byte(creds.SecretID), 0600)
+        if err != nil {
+            return err
+        }
+    } else {
+        if self.TokenFile == "" {
+            return errors.New("registered in " + resp.RegistrationMode + " mode but no --token-file given")
+        }
+        
+        log.Infof("writing perm token to %s", self.TokenFile)
+        err = bootstrap.WriteFile(self.TokenFile, []byte(creds.PermToken), 0600)
+        if err != nil {
+            return err
+        }
+    }
+    
+    if self.ConsulConfigFile != "" {
+        var aclToken string
+        if self.ConsulACL {
+            entries, err := c.ReadEntries(resp, []string{ "consul_acl" })
+            if err != nil {
+                return err
+            }
+            
+            aclToken, _ = entries["consul_acl"]["token"].(string)
+            if aclToken == "" {
+                return errors.New("role did not deliver a Consul ACL token")
+            }
+        }
+        
+        consulConfig, err := bootstrap.ConsulConfig(resp, aclToken)
+        if err != nil {
+            return err
+        }
+        
+        // may hold the ACL token
+        log.Infof("writing Consul config to %s", self.ConsulConfigFile)
+        err = bootstrap.WriteFile(self.ConsulConfigFile, consulConfig, 0600)
+        if err != nil {
+            return err
+        }
+    }
+    
+    return nil
+}
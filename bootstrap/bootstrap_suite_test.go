@@ -0,0 +1,15 @@
+package bootstrap_test
+
+import (
+    . "github.com/onsi/ginkgo"
+    . "github.com/onsi/gomega"
+    
+    "testing"
+    "github.com/Sirupsen/logrus"
+)
+
+func TestBootstrap(t *testing.T) {
+    RegisterFailHandler(Fail)
+    logrus.SetLevel(logrus.PanicLevel)
+    RunSpecs(t, "Bootstrap Suite")
+}
@@ -0,0 +1,32 @@
+package bootstrap
+
+import (
+    "encoding/json"
+    
+    "github.com/bluestatedigital/centralbooking/client"
+)
+
+// renders a Consul agent config file that joins the servers from the
+// registration over the WAN.  aclToken may be empty.
+func ConsulConfig(resp *client.RegisterResponse, aclToken string) ([]byte, error) {
+    servers := resp.ConsulServers
+    if servers == nil {
+        servers = []string{}
+    }
+    
+    cfg := map[string]interface{}{
+        "datacenter":     resp.Datacenter,
+        "retry_join_wan": servers,
+    }
+    
+    // ACLs may not be in use
+    if resp.ACLDatacenter != "" {
+        cfg["acl_datacenter"] = resp.ACLDatacenter
+    }
+    
+    if aclToken != "" {
+        cfg["acl_token"] = aclToken
+    }
+    
+    return json.MarshalIndent(cfg, "", "    ")
+}
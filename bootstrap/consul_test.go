@@ -0,0 +1,49 @@
+package bootstrap_test
+
+import (
+    "encoding/json"
+    
+    "github.com/bluestatedigital/centralbooking/bootstrap"
+    "github.com/bluestatedigital/centralbooking/client"
+    
+    . "github.com/onsi/ginkgo"
+    . "github.com/onsi/gomega"
+)
+
+var _ = Describe("ConsulConfig", func() {
+    render := func(resp *client.RegisterResponse, aclToken string) map[string]interface{} {
+        data, err := bootstrap.ConsulConfig(resp, aclToken)
+        Expect(err).To(BeNil())
+        
+        var cfg map[string]interface{}
+        Expect(json.Unmarshal(data, &cfg)).To(BeNil())
+        
+        return cfg
+    }
+    
+    It("joins the consul servers over the wan", func() {
+        cfg := render(&client.RegisterResponse{
+            ConsulServers: []string{ "10.0.1.1:8302", "10.0.1.2:8302" },
+            Datacenter:    "us-east-1a",
+            ACLDatacenter: "us-east-1",
+        }, "generated-acl-token")
+        
+        Expect(cfg).To(Equal(map[string]interface{}{
+            "datacenter":     "us-east-1a",
+            "retry_join_wan": []interface{}{ "10.0.1.1:8302", "10.0.1.2:8302" },
+            "acl_datacenter": "us-east-1",
+            "acl_token":      "generated-acl-token",
+        }))
+    })
+    
+    It("leaves out ACLs if they're not in use", func() {
+        cfg := render(&client.RegisterResponse{
+            Datacenter: "us-east-1a",
+        }, "")
+        
+        Expect(cfg).To(Equal(map[string]interface{}{
+            "datacenter":     "us-east-1a",
+            "retry_join_wan": []interface{}{},
+        }))
+    })
+})
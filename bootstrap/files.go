@@ -0,0 +1,36 @@
+package bootstrap
+
+import (
+    "os"
+    "io/ioutil"
+    "path/filepath"
+)
+
+// writes data to path with the given mode.  the data goes to a temporary file
+// in the same directory first, so the file is never seen partially written or
+// with looser permissions.
+func WriteFile(path string, data []byte, mode os.FileMode) error {
+    tmp, err := ioutil.TempFile(filepath.Dir(path), "." + filepath.Base(path))
+    if err != nil {
+        return err
+    }
+    
+    // no-op once renamed
+    defer os.Remove(tmp.Name())
+    
+    err = tmp.Chmod(mode)
+    if err == nil {
+        _, err = tmp.Write(data)
+    }
+    
+    closeErr := tmp.Close()
+    if err == nil {
+        err = closeErr
+    }
+    
+    if err != nil {
+        return err
+    }
+    
+    return os.Rename(tmp.Name(), path)
+}
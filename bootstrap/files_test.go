@@ -0,0 +1,60 @@
+package bootstrap_test
+
+import (
+    "os"
+    "io/ioutil"
+    "path/filepath"
+    
+    "github.com/bluestatedigital/centralbooking/bootstrap"
+    
+    . "github.com/onsi/ginkgo"
+    . "github.com/onsi/gomega"
+)
+
+var _ = Describe("WriteFile", func() {
+    var dir string
+    
+    BeforeEach(func() {
+        var err error
+        dir, err = ioutil.TempDir("", "bootstrap")
+        Expect(err).To(BeNil())
+    })
+    
+    AfterEach(func() {
+        os.RemoveAll(dir)
+    })
+    
+    It("writes the file with the given mode", func() {
+        path := filepath.Join(dir, "token")
+        
+        Expect(bootstrap.WriteFile(path, []byte("generated-perm-token"), 0600)).To(BeNil())
+        
+        info, err := os.Stat(path)
+        Expect(err).To(BeNil())
+        Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+        
+        data, err := ioutil.ReadFile(path)
+        Expect(err).To(BeNil())
+        Expect(string(data)).To(Equal("generated-perm-token"))
+    })
+    
+    It("replaces an existing file", func() {
+        path := filepath.Join(dir, "token")
+        Expect(ioutil.WriteFile(path, []byte("old-token"), 0644)).To(BeNil())
+        
+        Expect(bootstrap.WriteFile(path, []byte("generated-perm-token"), 0600)).To(BeNil())
+        
+        info, err := os.Stat(path)
+        Expect(err).To(BeNil())
+        Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+        
+        data, err := ioutil.ReadFile(path)
+        Expect(err).To(BeNil())
+        Expect(string(data)).To(Equal("generated-perm-token"))
+        
+        // no temporary files left behind
+        files, err := ioutil.ReadDir(dir)
+        Expect(err).To(BeNil())
+        Expect(files).To(HaveLen(1))
+    })
+})
@@ -0,0 +1,120 @@
+// helpers for bootstrapping a freshly-launched instance with centralbooking
+package bootstrap
+
+import (
+    "fmt"
+    "strings"
+    "context"
+    "net/http"
+    "io/ioutil"
+    "encoding/json"
+)
+
+const DefaultMetadataEndpoint = "http://169.254.169.254/latest"
+
+// lifetime of the IMDSv2 session token; it's only needed for a couple of
+// requests
+const metadataTokenTTL = "60"
+
+// the instance's identity, from the EC2 metadata service
+type Identity struct {
+    InstanceID string `json:"instanceId"`
+    AccountID  string `json:"accountId"`
+    Region     string `json:"region"`
+    
+    // the raw identity document and its base64-encoded PKCS7 signature,
+    // passed on to centralbooking as-is
+    Document   string `json:"-"`
+    Signature  string `json:"-"`
+}
+
+// retrieves the instance identity document and its signature from the
+// metadata service at endpoint, using an IMDSv2 session token if the service
+// hands one out.  httpClient may be nil.
+func GetIdentity(ctx context.Context, httpClient *http.Client, endpoint string) (*Identity, error) {
+    if httpClient == nil {
+        httpClient = http.DefaultClient
+    }
+    
+    endpoint = strings.TrimRight(endpoint, "/")
+    baseURL := endpoint + "/dynamic/instance-identity/"
+    
+    // without a token the service is used as IMDSv1; if it requires v2 the
+    // requests below will fail anyway
+    token, err := getMetadataToken(ctx, httpClient, endpoint + "/api/token")
+    if err != nil && ctx.Err() != nil {
+        return nil, ctx.Err()
+    }
+    
+    doc, err := getMetadata(ctx, httpClient, baseURL + "document", token)
+    if err != nil {
+        return nil, err
+    }
+    
+    sig, err := getMetadata(ctx, httpClient, baseURL + "rsa2048", token)
+    if err != nil {
+        return nil, err
+    }
+    
+    identity := &Identity{
+        Document:  doc,
+        Signature: sig,
+    }
+    
+    err = json.Unmarshal([]byte(doc), identity)
+    if err != nil {
+        return nil, fmt.Errorf("unable to decode identity document: %s", err)
+    }
+    
+    if identity.InstanceID == "" || identity.Region == "" {
+        return nil, fmt.Errorf("incomplete identity document")
+    }
+    
+    return identity, nil
+}
+
+// requests an IMDSv2 session token
+func getMetadataToken(ctx context.Context, httpClient *http.Client, url string) (string, error) {
+    req, err := http.NewRequest("PUT", url, nil)
+    if err != nil {
+        return "", err
+    }
+    
+    req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", metadataTokenTTL)
+    
+    return doMetadataRequest(ctx, httpClient, req)
+}
+
+// token is the IMDSv2 session token, if any
+func getMetadata(ctx context.Context, httpClient *http.Client, url string, token string) (string, error) {
+    req, err := http.NewRequest("GET", url, nil)
+    if err != nil {
+        return "", err
+    }
+    
+    if token != "" {
+        req.Header.Set("X-aws-ec2-metadata-token", token)
+    }
+    
+    return doMetadataRequest(ctx, httpClient, req)
+}
+
+func doMetadataRequest(ctx context.Context, httpClient *http.Client, req *http.Request) (string, error) {
+    resp, err := httpClient.Do(req.WithContext(ctx))
+    if err != nil {
+        return "", err
+    }
+    
+    defer resp.Body.Close()
+    
+    body, err := ioutil.ReadAll(resp.Body)
+    if err != nil {
+        return "", err
+    }
+    
+    if resp.StatusCode != http.StatusOK {
+        return "", fmt.Errorf("unable to retrieve %s: status %d", req.URL, resp.StatusCode)
+    }
+    
+    return string(body), nil
+}
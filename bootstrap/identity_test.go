@@ -0,0 +1,99 @@
+package bootstrap_test
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    
+    "github.com/bluestatedigital/centralbooking/bootstrap"
+    
+    . "github.com/onsi/ginkgo"
+    . "github.com/onsi/gomega"
+)
+
+var _ = Describe("GetIdentity", func() {
+    var metadata map[string]string
+    var server *httptest.Server
+    
+    // the IMDSv2 session token handed out, if any, and whether requests
+    // without it are refused
+    var sessionToken string
+    var requireToken bool
+    
+    BeforeEach(func() {
+        metadata = map[string]string{
+            "/latest/dynamic/instance-identity/document": `{"instanceId":"i-04c9c4c4","accountId":"123456789012","region":"us-east-1"}`,
+            "/latest/dynamic/instance-identity/rsa2048":  "cGtjczcgc2ln\nbmF0dXJl",
+        }
+        
+        sessionToken = ""
+        requireToken = false
+        
+        server = httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+            if req.URL.Path == "/latest/api/token" && sessionToken != "" {
+                if req.Method != "PUT" || req.Header.Get("X-aws-ec2-metadata-token-ttl-seconds") == "" {
+                    http.Error(resp, "bad request", http.StatusBadRequest)
+                    return
+                }
+                
+                resp.Write([]byte(sessionToken))
+                return
+            }
+            
+            if requireToken && req.Header.Get("X-aws-ec2-metadata-token") != sessionToken {
+                http.Error(resp, "unauthorized", http.StatusUnauthorized)
+                return
+            }
+            
+            body, ok := metadata[req.URL.Path]
+            if ! ok {
+                http.NotFound(resp, req)
+                return
+            }
+            
+            resp.Write([]byte(body))
+        }))
+    })
+    
+    AfterEach(func() {
+        server.Close()
+    })
+    
+    It("retrieves the identity document and signature", func() {
+        identity, err := bootstrap.GetIdentity(context.Background(), nil, server.URL + "/latest/")
+        Expect(err).To(BeNil())
+        
+        Expect(identity.InstanceID).To(Equal("i-04c9c4c4"))
+        Expect(identity.AccountID).To(Equal("123456789012"))
+        Expect(identity.Region).To(Equal("us-east-1"))
+        
+        // passed on untouched
+        Expect(identity.Document).To(Equal(metadata["/latest/dynamic/instance-identity/document"]))
+        Expect(identity.Signature).To(Equal("cGtjczcgc2ln\nbmF0dXJl"))
+    })
+    
+    It("uses an IMDSv2 session token", func() {
+        sessionToken = "AQAEAHBxcv8example"
+        requireToken = true
+        
+        identity, err := bootstrap.GetIdentity(context.Background(), nil, server.URL + "/latest")
+        Expect(err).To(BeNil())
+        
+        Expect(identity.InstanceID).To(Equal("i-04c9c4c4"))
+        Expect(identity.Signature).To(Equal("cGtjczcgc2ln\nbmF0dXJl"))
+    })
+    
+    It("fails if the signature is unavailable", func() {
+        delete(metadata, "/latest/dynamic/instance-identity/rsa2048")
+        
+        _, err := bootstrap.GetIdentity(context.Background(), nil, server.URL + "/latest")
+        Expect(err).To(MatchError(ContainSubstring("status 404")))
+    })
+    
+    It("fails if the document is incomplete", func() {
+        metadata["/latest/dynamic/instance-identity/document"] = `{"accountId":"123456789012"}`
+        
+        _, err := bootstrap.GetIdentity(context.Background(), nil, server.URL + "/latest")
+        Expect(err).NotTo(BeNil())
+    })
+})
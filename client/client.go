@@ -0,0 +1,214 @@
+// client for centralbooking's v1 API, for instances to register themselves
+// with
+package client
+
+import (
+    log "github.com/Sirupsen/logrus"
+    "fmt"
+    "time"
+    "bytes"
+    "errors"
+    "context"
+    "strings"
+    "net/http"
+    "io/ioutil"
+    "encoding/json"
+)
+
+const (
+    DefaultMaxAttempts   = 5
+    DefaultRetryDelay    = time.Second
+    DefaultMaxRetryDelay = 30 * time.Second
+)
+
+type Client struct {
+    endpoint    string
+    httpClient  *http.Client
+    
+    // registration is attempted at most this many times.  the delay between
+    // attempts starts at RetryDelay and doubles after each one, up to
+    // MaxRetryDelay.
+    MaxAttempts   int
+    RetryDelay    time.Duration
+    MaxRetryDelay time.Duration
+}
+
+// endpoint is centralbooking's base URL, like "http://centralbooking".
+// httpClient may be nil.
+func NewClient(endpoint string, httpClient *http.Client) *Client {
+    if httpClient == nil {
+        httpClient = &http.Client{
+            Timeout: 30 * time.Second,
+        }
+    }
+    
+    return &Client{
+        endpoint:   strings.TrimRight(endpoint, "/"),
+        httpClient: httpClient,
+        
+        MaxAttempts:   DefaultMaxAttempts,
+        RetryDelay:    DefaultRetryDelay,
+        MaxRetryDelay: DefaultMaxRetryDelay,
+    }
+}
+
+type RegisterRequest struct {
+    Environment string   `json:"environment"`
+    Provider    string   `json:"provider"`
+    Account     string   `json:"account"`
+    Region      string   `json:"region"`
+    InstanceID  string   `json:"instance_id"`
+    Role        string   `json:"role"`
+    Policies    []string `json:"policies,omitempty"`
+    
+    // the instance identity document and its base64-encoded PKCS7 signature,
+    // as retrieved from the EC2 metadata service
+    IdentityDocument  string `json:"identity_document"`
+    IdentitySignature string `json:"identity_signature"`
+    
+    SSHHostPublicKey  string `json:"ssh_host_public_key,omitempty"`
+    
+    // exchange the temp token for the instance's credentials once registered,
+    // also reading these cubbyhole entries, like "pki" or "consul_acl"
+    Exchange         bool     `json:"-"`
+    CubbyholeEntries []string `json:"-"`
+}
+
+type RegisterResponse struct {
+    TempToken          string   `json:"temp_token"`
+    RegistrationMode   string   `json:"registration_mode"`
+    VaultEndpoint      string   `json:"vault_endpoint"`
+    ConsulServers      []string `json:"consul_servers"`
+    Datacenter         string   `json:"datacenter"`
+    ACLDatacenter      string   `json:"acl_datacenter"`
+    SSHHostCertificate string   `json:"ssh_host_certificate"`
+    
+    // set if the exchange was requested
+    Credentials        *Credentials `json:"-"`
+}
+
+// returned for responses other than 200
+type StatusError struct {
+    StatusCode int
+    Message    string
+}
+
+func (self *StatusError) Error() string {
+    return fmt.Sprintf("centralbooking returned %d: %s", self.StatusCode, self.Message)
+}
+
+// true if the request may succeed if tried again.  other 4xx responses, like
+// 409 for an instance that's already registered, won't change.
+func (self *StatusError) Temporary() bool {
+    return self.StatusCode >= 500 || self.StatusCode == http.StatusTooManyRequests
+}
+
+// registers the instance, retrying connection errors and temporary failures
+// until ctx is done.  the exchange, if requested, is not retried as the temp
+// token only allows so many uses; if it fails the response is returned along
+// with the error, with whatever credentials were read before the failure.
+func (self *Client) Register(ctx context.Context, req *RegisterRequest) (*RegisterResponse, error) {
+    body, err := json.Marshal(req)
+    if err != nil {
+        return nil, err
+    }
+    
+    logEntry := log.WithFields(log.Fields{
+        "environment": req.Environment,
+        "provider":    req.Provider,
+        "account":     req.Account,
+        "region":      req.Region,
+        "instance_id": req.InstanceID,
+        "role":        req.Role,
+    })
+    
+    var resp *RegisterResponse
+    delay := self.RetryDelay
+    for attempt := 1; ; attempt++ {
+        var retry bool
+        resp, retry, err = self.register(ctx, body)
+        if err == nil {
+            break
+        }
+        
+        if ctx.Err() != nil {
+            return nil, ctx.Err()
+        }
+        
+        if ! retry {
+            return nil, err
+        }
+        
+        if attempt >= self.MaxAttempts {
+            return nil, fmt.Errorf("giving up after %d attempts: %s", attempt, err)
+        }
+        
+        logEntry.Warnf("registration attempt %d failed, retrying in %s: %s", attempt, delay, err)
+        
+        select {
+            case <-ctx.Done():
+                return nil, ctx.Err()
+            
+            case <-time.After(delay):
+        }
+        
+        delay *= 2
+        if delay > self.MaxRetryDelay {
+            delay = self.MaxRetryDelay
+        }
+    }
+    
+    if req.Exchange {
+        resp.Credentials, err = self.Exchange(resp, req.CubbyholeEntries)
+        if err != nil {
+            return resp, err
+        }
+    }
+    
+    return resp, nil
+}
+
+// makes a single registration attempt.  also returns true if a failed attempt
+// may be retried; once centralbooking has accepted the registration another
+// one would only be rejected.
+func (self *Client) register(ctx context.Context, body []byte) (*RegisterResponse, bool, error) {
+    httpReq, err := http.NewRequest("POST", self.endpoint + "/v1/register/instance", bytes.NewReader(body))
+    if err != nil {
+        return nil, false, err
+    }
+    
+    httpReq.Header.Set("Content-Type", "application/json")
+    
+    httpResp, err := self.httpClient.Do(httpReq.WithContext(ctx))
+    if err != nil {
+        return nil, true, err
+    }
+    
+    defer httpResp.Body.Close()
+    
+    respBody, err := ioutil.ReadAll(httpResp.Body)
+    if err != nil {
+        return nil, true, err
+    }
+    
+    if httpResp.StatusCode != http.StatusOK {
+        statusErr := &StatusError{
+            StatusCode: httpResp.StatusCode,
+            Message:    strings.TrimSpace(string(respBody)),
+        }
+        
+        return nil, statusErr.Temporary(), statusErr
+    }
+    
+    var resp RegisterResponse
+    err = json.Unmarshal(respBody, &resp)
+    if err != nil {
+        return nil, false, fmt.Errorf("unable to decode response: %s", err)
+    }
+    
+    if resp.TempToken == "" {
+        return nil, false, errors.New("no temp_token in response")
+    }
+    
+    return &resp, false, nil
+}
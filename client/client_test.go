@@ -0,0 +1,407 @@
+package client_test
+
+import (
+    "github.com/bluestatedigital/centralbooking/client"
+    "github.com/bluestatedigital/centralbooking/config"
+    "github.com/bluestatedigital/centralbooking/interfaces"
+    "github.com/bluestatedigital/centralbooking/instance"
+    "github.com/bluestatedigital/centralbooking/v1"
+    
+    vaultapi "github.com/hashicorp/vault/api"
+    consulapi "github.com/hashicorp/consul/api"
+    "github.com/aws/aws-sdk-go/aws"
+    "github.com/aws/aws-sdk-go/aws/ec2metadata"
+    "github.com/aws/aws-sdk-go/service/ec2"
+    
+    . "github.com/onsi/ginkgo"
+    . "github.com/onsi/gomega"
+    
+    "github.com/stretchr/testify/mock"
+    
+    "time"
+    "strings"
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "github.com/gorilla/mux"
+)
+
+var _ = Describe("Client", func() {
+    var cbServer *httptest.Server
+    var vaultServer *httptest.Server
+    var c *client.Client
+    var req *client.RegisterRequest
+    
+    // requests to centralbooking, and how many of them fail before passing
+    // the request on
+    var attempts int
+    var failures int
+    
+    // uses the temp token has left
+    var tempTokenUses int
+    
+    var mockVaultClient interfaces.MockVaultClient
+    var mockVaultClientTemp interfaces.MockVaultClient
+    var mockIdentityVerifier interfaces.MockIdentityVerifier
+    var mockEC2Describer interfaces.MockEC2Describer
+    var mockConsulKV interfaces.MockConsulKV
+    var mockConsulACL interfaces.MockConsulACL
+    var mockConsulCatalog interfaces.MockConsulCatalog
+    var mockConsulAgent interfaces.MockConsulAgent
+    var cfg *config.Config
+    
+    BeforeEach(func() {
+        attempts = 0
+        failures = 0
+        tempTokenUses = 10
+        
+        mockVaultClient = interfaces.MockVaultClient{}
+        mockVaultClientTemp = interfaces.MockVaultClient{}
+        mockIdentityVerifier = interfaces.MockIdentityVerifier{}
+        mockEC2Describer = interfaces.MockEC2Describer{}
+        mockConsulKV = interfaces.MockConsulKV{}
+        mockConsulACL = interfaces.MockConsulACL{}
+        mockConsulCatalog = interfaces.MockConsulCatalog{}
+        mockConsulAgent = interfaces.MockConsulAgent{}
+        
+        cfg = &config.Config{
+            AWS: config.AWSConfig{
+                Accounts: map[string]string{
+                    "gen": "123456789012",
+                },
+                MaxLaunchAge: config.Duration{ Duration: 10 * time.Minute },
+            },
+            Vault: config.VaultConfig{
+                AdminPolicy: "centralbooking-admin",
+            },
+            Roles: []config.RoleConfig{
+                config.RoleConfig{
+                    Environment: "dev",
+                    Provider:    "aws",
+                    Role:        "cluster-server",
+                    Policies:    []string{ "instance-management" },
+                },
+            },
+        }
+        
+        // fills in defaults
+        Expect(cfg.Validate()).To(BeNil())
+        
+        // only knows the temp token
+        vaultServer = httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+            resp.Header().Set("Content-Type", "application/json")
+            
+            if req.Header.Get("X-Vault-Token") != "generated-temp-token" || tempTokenUses == 0 {
+                http.Error(resp, `{"errors":["permission denied"]}`, http.StatusForbidden)
+                return
+            }
+            
+            tempTokenUses--
+            
+            switch req.URL.Path {
+                case "/v1/cubbyhole/perm":
+                    resp.Write([]byte(`{"data":{"payload":{"auth":{"client_token":"generated-perm-token"}}}}`))
+                
+                case "/v1/cubbyhole/consul_acl":
+                    resp.Write([]byte(`{"data":{"token":"generated-acl-token"}}`))
+                
+                case "/v1/sys/wrapping/unwrap":
+                    resp.Write([]byte(`{"auth":{"client_token":"generated-perm-token"}}`))
+                
+                default:
+                    http.Error(resp, `{"errors":[]}`, http.StatusNotFound)
+            }
+        }))
+        
+        router := mux.NewRouter()
+        v1.NewCentralBooking(
+            instance.NewRegistrar(
+                &mockVaultClient,
+                &mockIdentityVerifier,
+                &mockEC2Describer,
+                &mockConsulKV,
+                &mockConsulACL,
+                cfg,
+            ),
+            &mockConsulCatalog,
+            &mockConsulAgent,
+            vaultServer.URL,
+            &mockVaultClient,
+            nil,
+            cfg,
+        ).InstallHandlers(router.PathPrefix("/v1").Subrouter())
+        
+        cbServer = httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+            attempts += 1
+            if attempts <= failures {
+                http.Error(resp, "try again later", http.StatusServiceUnavailable)
+                return
+            }
+            
+            // as if the request came from the instance itself
+            req.RemoteAddr = "10.112.16.35:41234"
+            
+            router.ServeHTTP(resp, req)
+        }))
+        
+        c = client.NewClient(cbServer.URL + "/", nil)
+        c.RetryDelay = time.Millisecond
+        
+        req = &client.RegisterRequest{
+            Environment: "dev",
+            Provider:    "aws",
+            Account:     "gen",
+            Region:      "us-east-1",
+            InstanceID:  "i-04c9c4c4",
+            Role:        "cluster-server",
+            
+            IdentityDocument:  `{"instanceId":"i-04c9c4c4"}`,
+            IdentitySignature: "cGtjczcgc2ln\nbmF0dXJl",
+        }
+        
+        // not registered yet
+        mockConsulKV.
+            On("Get", mock.AnythingOfType("string"), mock.AnythingOfType("*api.QueryOptions")).
+            Return(nil, nil, nil)
+        
+        mockConsulAgent.
+            On("Self").
+            Return(map[string]map[string]interface{}{
+                "Config": map[string]interface{}{
+                    "Datacenter": "us-east-1a",
+                },
+            }, nil)
+        
+        mockConsulCatalog.
+            On("Service", "consul-wan", "", mock.AnythingOfType("*api.QueryOptions")).
+            Return(
+                []*consulapi.CatalogService{
+                    &consulapi.CatalogService{
+                        ServiceAddress: "127.0.0.2",
+                        ServicePort:    8302,
+                    },
+                },
+                nil,
+                nil,
+            )
+        
+        mockIdentityVerifier.
+            On("Verify", []byte(`{"instanceId":"i-04c9c4c4"}`), []byte("pkcs7 signature")).
+            Return(&ec2metadata.EC2InstanceIdentityDocument{
+                InstanceID: "i-04c9c4c4",
+                AccountID:  "123456789012",
+                Region:     "us-east-1",
+            }, nil)
+        
+        mockEC2Describer.
+            On("DescribeInstances", "123456789012", "us-east-1", mock.AnythingOfType("*ec2.DescribeInstancesInput")).
+            Return(
+                &ec2.DescribeInstancesOutput{
+                    Reservations: []*ec2.Reservation{
+                        &ec2.Reservation{
+                            Instances: []*ec2.Instance{
+                                &ec2.Instance{
+                                    InstanceId:       aws.String("i-04c9c4c4"),
+                                    State:            &ec2.InstanceState{ Name: aws.String("running") },
+                                    LaunchTime:       aws.Time(time.Now().Add(-time.Minute)),
+                                    PrivateIpAddress: aws.String("10.112.16.35"),
+                                },
+                            },
+                        },
+                    },
+                },
+                nil,
+            )
+        
+        mockConsulKV.
+            On("CAS", mock.AnythingOfType("*api.KVPair"), mock.AnythingOfType("*api.WriteOptions")).
+            Return(true, nil, nil)
+    })
+    
+    AfterEach(func() {
+        cbServer.Close()
+        vaultServer.Close()
+    })
+    
+    Describe("in cubbyhole mode", func() {
+        BeforeEach(func() {
+            mockVaultClient.
+                On("CreateToken", mock.MatchedBy(func(req *vaultapi.TokenCreateRequest) bool {
+                    return strings.HasPrefix(req.DisplayName, "perm ")
+                })).
+                Return(&vaultapi.Secret{
+                    Auth: &vaultapi.SecretAuth{
+                        ClientToken: "generated-perm-token",
+                        Accessor:    "perm-accessor",
+                    },
+                }, nil)
+            
+            mockVaultClient.
+                On("CreateToken", mock.MatchedBy(func(req *vaultapi.TokenCreateRequest) bool {
+                    return strings.HasPrefix(req.DisplayName, "temp ")
+                })).
+                Return(&vaultapi.Secret{
+                    Auth: &vaultapi.SecretAuth{
+                        ClientToken: "generated-temp-token",
+                    },
+                }, nil)
+            
+            mockVaultClient.On("WithToken", "generated-temp-token").Return(&mockVaultClientTemp)
+            mockVaultClientTemp.
+                On("WriteSecret", "cubbyhole/perm", mock.AnythingOfType("map[string]interface {}")).
+                Return(nil, nil)
+        })
+        
+        It("registers the instance", func() {
+            resp, err := c.Register(context.Background(), req)
+            Expect(err).To(BeNil())
+            
+            Expect(resp.TempToken).To(Equal("generated-temp-token"))
+            Expect(resp.RegistrationMode).To(Equal("cubbyhole"))
+            Expect(resp.VaultEndpoint).To(Equal(vaultServer.URL))
+            Expect(resp.ConsulServers).To(Equal([]string{ "127.0.0.2:8302" }))
+            Expect(resp.Datacenter).To(Equal("us-east-1a"))
+            Expect(resp.Credentials).To(BeNil())
+            
+            Expect(attempts).To(Equal(1))
+            
+            mockIdentityVerifier.AssertExpectations(GinkgoT())
+            mockVaultClient.AssertExpectations(GinkgoT())
+            mockVaultClientTemp.AssertExpectations(GinkgoT())
+            mockConsulKV.AssertExpectations(GinkgoT())
+        })
+        
+        It("exchanges the temp token for the perm token", func() {
+            req.Exchange = true
+            req.CubbyholeEntries = []string{ "consul_acl", "pki" }
+            
+            resp, err := c.Register(context.Background(), req)
+            Expect(err).To(BeNil())
+            
+            Expect(resp.Credentials).NotTo(BeNil())
+            Expect(resp.Credentials.PermToken).To(Equal("generated-perm-token"))
+            
+            // the role doesn't deliver a certificate
+            Expect(resp.Credentials.Entries).To(Equal(map[string]map[string]interface{}{
+                "consul_acl": map[string]interface{}{
+                    "token": "generated-acl-token",
+                },
+            }))
+        })
+        
+        It("returns the credentials read before the exchange failed", func() {
+            // the certificate isn't delivered, but looking for it uses up the
+            // token
+            tempTokenUses = 2
+            
+            req.Exchange = true
+            req.CubbyholeEntries = []string{ "pki", "consul_acl" }
+            
+            resp, err := c.Register(context.Background(), req)
+            Expect(err).To(MatchError(ContainSubstring("unable to read cubbyhole/consul_acl")))
+            
+            Expect(resp.Credentials).NotTo(BeNil())
+            Expect(resp.Credentials.PermToken).To(Equal("generated-perm-token"))
+            Expect(resp.Credentials.Entries).To(BeEmpty())
+        })
+        
+        It("reads cubbyhole entries after the exchange", func() {
+            req.Exchange = true
+            
+            resp, err := c.Register(context.Background(), req)
+            Expect(err).To(BeNil())
+            Expect(resp.Credentials.PermToken).To(Equal("generated-perm-token"))
+            
+            entries, err := c.ReadEntries(resp, []string{ "pki", "consul_acl" })
+            Expect(err).To(BeNil())
+            Expect(entries).To(Equal(map[string]map[string]interface{}{
+                "consul_acl": map[string]interface{}{
+                    "token": "generated-acl-token",
+                },
+            }))
+        })
+        
+        It("retries temporary failures", func() {
+            failures = 2
+            
+            resp, err := c.Register(context.Background(), req)
+            Expect(err).To(BeNil())
+            Expect(resp.TempToken).To(Equal("generated-temp-token"))
+            
+            Expect(attempts).To(Equal(3))
+        })
+        
+        It("gives up after the maximum number of attempts", func() {
+            failures = 10
+            c.MaxAttempts = 3
+            
+            _, err := c.Register(context.Background(), req)
+            Expect(err).To(MatchError(ContainSubstring("giving up after 3 attempts")))
+            
+            Expect(attempts).To(Equal(3))
+            mockVaultClient.AssertNotCalled(GinkgoT(), "CreateToken", mock.Anything)
+        })
+        
+        It("stops retrying when the context is done", func() {
+            failures = 10
+            c.RetryDelay = time.Minute
+            
+            ctx, cancel := context.WithTimeout(context.Background(), 50 * time.Millisecond)
+            defer cancel()
+            
+            _, err := c.Register(ctx, req)
+            Expect(err).To(Equal(context.DeadlineExceeded))
+            
+            Expect(attempts).To(Equal(1))
+        })
+    })
+    
+    It("does not retry rejected registrations", func() {
+        req.Role = "web-server"
+        
+        _, err := c.Register(context.Background(), req)
+        Expect(err).To(BeAssignableToTypeOf(&client.StatusError{}))
+        Expect(err.(*client.StatusError).StatusCode).To(Equal(400))
+        
+        Expect(attempts).To(Equal(1))
+    })
+    
+    Describe("in wrap mode", func() {
+        BeforeEach(func() {
+            cfg.Vault.RegistrationMode = config.RegistrationModeWrap
+            
+            mockVaultClientWrap := interfaces.MockVaultClient{}
+            mockVaultClient.On("WithWrapTTL", "15s").Return(&mockVaultClientWrap)
+            
+            mockVaultClientWrap.
+                On("CreateToken", mock.AnythingOfType("*api.TokenCreateRequest")).
+                Return(&vaultapi.Secret{
+                    WrapInfo: &vaultapi.SecretWrapInfo{
+                        Token:           "generated-temp-token",
+                        WrappedAccessor: "perm-accessor",
+                    },
+                }, nil)
+        })
+        
+        It("unwraps the perm token", func() {
+            req.Exchange = true
+            
+            resp, err := c.Register(context.Background(), req)
+            Expect(err).To(BeNil())
+            
+            Expect(resp.RegistrationMode).To(Equal("wrap"))
+            Expect(resp.Credentials.PermToken).To(Equal("generated-perm-token"))
+        })
+        
+        It("returns the response if the exchange fails", func() {
+            req.Exchange = true
+            req.CubbyholeEntries = []string{ "pki" }
+            
+            resp, err := c.Register(context.Background(), req)
+            Expect(err).NotTo(BeNil())
+            
+            Expect(resp.TempToken).To(Equal("generated-temp-token"))
+            Expect(resp.Credentials).To(BeNil())
+        })
+    })
+})
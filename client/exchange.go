@@ -0,0 +1,172 @@
+package client
+
+import (
+    "fmt"
+    "errors"
+    "encoding/json"
+    
+    vaultapi "github.com/hashicorp/vault/api"
+)
+
+// what the temp token is exchanged for
+type Credentials struct {
+    // the perm token, in "cubbyhole" and "wrap" modes
+    PermToken string
+    
+    // the AppRole credentials to log in with, in "approle" mode
+    RoleID    string
+    SecretID  string
+    
+    // the requested cubbyhole entries, by name.  entries the role doesn't
+    // deliver are left out, but looking for them still costs one of the temp
+    // token's uses, and once those run out the remaining entries can't be
+    // read.
+    Entries   map[string]map[string]interface{}
+}
+
+// exchanges the temp token from a registration for the instance's
+// credentials, via the Vault server in the response.  each entry read costs
+// one of the temp token's uses, whether or not the role delivers it, so only
+// request those it does.  if reading an entry fails, the credentials read so
+// far are returned with the error; the temp token can't be exchanged again.
+func (self *Client) Exchange(resp *RegisterResponse, entries []string) (*Credentials, error) {
+    vault, err := tempTokenClient(resp)
+    if err != nil {
+        return nil, err
+    }
+    
+    creds := &Credentials{
+        Entries: map[string]map[string]interface{}{},
+    }
+    
+    switch resp.RegistrationMode {
+        case "cubbyhole", "":
+            secret, err := readCubbyhole(vault, "perm")
+            if err != nil {
+                return nil, err
+            }
+            
+            // the perm token's secret, as Vault returned it to centralbooking
+            payloadJSON, err := json.Marshal(secret["payload"])
+            if err != nil {
+                return nil, err
+            }
+            
+            var permSecret vaultapi.Secret
+            err = json.Unmarshal(payloadJSON, &permSecret)
+            if err != nil || permSecret.Auth == nil {
+                return nil, errors.New("invalid payload in cubbyhole/perm")
+            }
+            
+            creds.PermToken = permSecret.Auth.ClientToken
+        
+        case "wrap":
+            if len(entries) > 0 {
+                return nil, errors.New("cubbyhole entries are not delivered in wrap mode")
+            }
+            
+            // an empty wrapping token unwraps the client's own
+            permSecret, err := vault.Logical().Unwrap("")
+            if err != nil {
+                return nil, fmt.Errorf("unable to unwrap perm token: %s", err)
+            }
+            
+            if permSecret == nil || permSecret.Auth == nil {
+                return nil, errors.New("no perm token in wrapped response")
+            }
+            
+            creds.PermToken = permSecret.Auth.ClientToken
+        
+        case "approle":
+            secret, err := readCubbyhole(vault, "approle")
+            if err != nil {
+                return nil, err
+            }
+            
+            creds.RoleID, _ = secret["role_id"].(string)
+            creds.SecretID, _ = secret["secret_id"].(string)
+        
+        default:
+            return nil, fmt.Errorf("unsupported registration mode %q", resp.RegistrationMode)
+    }
+    
+    err = readEntries(vault, entries, creds.Entries)
+    if err != nil {
+        return creds, err
+    }
+    
+    return creds, nil
+}
+
+// reads cubbyhole entries with the temp token from a registration that has
+// already been exchanged, so the perm token or AppRole credentials can be
+// saved before risking the token's remaining uses on entries.  entries the
+// role doesn't deliver are left out; if reading one fails, those read so far
+// are returned with the error.
+func (self *Client) ReadEntries(resp *RegisterResponse, entries []string) (map[string]map[string]interface{}, error) {
+    if resp.RegistrationMode == "wrap" && len(entries) > 0 {
+        return nil, errors.New("cubbyhole entries are not delivered in wrap mode")
+    }
+    
+    vault, err := tempTokenClient(resp)
+    if err != nil {
+        return nil, err
+    }
+    
+    read := map[string]map[string]interface{}{}
+    
+    err = readEntries(vault, entries, read)
+    if err != nil {
+        return read, err
+    }
+    
+    return read, nil
+}
+
+// a Vault client for the registration's Vault server, using its temp token
+func tempTokenClient(resp *RegisterResponse) (*vaultapi.Client, error) {
+    cfg := vaultapi.DefaultConfig()
+    cfg.ReadEnvironment()
+    cfg.Address = resp.VaultEndpoint
+    
+    vault, err := vaultapi.NewClient(cfg)
+    if err != nil {
+        return nil, err
+    }
+    
+    vault.SetToken(resp.TempToken)
+    
+    return vault, nil
+}
+
+func readEntries(vault *vaultapi.Client, entries []string, into map[string]map[string]interface{}) error {
+    for _, name := range entries {
+        secret, err := readCubbyhole(vault, name)
+        if err == errNoEntry {
+            continue
+        }
+        
+        if err != nil {
+            return err
+        }
+        
+        into[name] = secret
+    }
+    
+    return nil
+}
+
+var errNoEntry = errors.New("no such cubbyhole entry")
+
+func readCubbyhole(vault *vaultapi.Client, name string) (map[string]interface{}, error) {
+    secret, err := vault.Logical().Read("cubbyhole/" + name)
+    if err != nil {
+        return nil, fmt.Errorf("unable to read cubbyhole/%s: %s", name, err)
+    }
+    
+    if secret == nil {
+        return nil, errNoEntry
+    }
+    
+    return secret.Data, nil
+}
@@ -0,0 +1,286 @@
+// centralbooking configuration file
+package config
+
+import (
+    "fmt"
+    "errors"
+    "time"
+    "strings"
+    "io/ioutil"
+    "encoding/json"
+)
+
+type Config struct {
+    AWS    AWSConfig    `json:"aws"`
+    Vault  VaultConfig  `json:"vault"`
+    Consul ConsulConfig `json:"consul"`
+    
+    Reconciler ReconcilerConfig `json:"reconciler"`
+    
+    // keyed by environment name
+    Environments map[string]EnvironmentConfig `json:"environments"`
+    
+    // evaluated in order; the first match wins
+    Roles  []RoleConfig `json:"roles"`
+}
+
+type AWSConfig struct {
+    // PEM bundle of AWS public certificates used to verify instance identity
+    // documents
+    IdentityCertificates string `json:"identity_certificates"`
+
+    // maps account names (as provided by registering instances) to AWS
+    // account IDs.  if not empty, only these names may register.
+    Accounts map[string]string `json:"accounts"`
+    
+    // maps AWS account IDs to the IAM role assumed to describe their
+    // instances.  accounts without a role are described with centralbooking's
+    // own credentials, and only if those belong to the account.
+    AssumeRoles map[string]string `json:"assume_roles"`
+    
+    // alternate EC2 API endpoint; the default for the region is used if empty
+    EC2Endpoint string `json:"ec2_endpoint"`
+    
+    // alternate STS API endpoint; the default for the region is used if empty
+    STSEndpoint string `json:"sts_endpoint"`
+    
+    // instances must register within this long of launching
+    MaxLaunchAge Duration `json:"max_launch_age"`
+}
+
+type VaultConfig struct {
+    // how the perm token is delivered to the instance; one of the
+    // RegistrationMode* constants
+    RegistrationMode string `json:"registration_mode"`
+    
+    // lifetime of the wrapping token in "wrap" mode
+    WrapTTL Duration `json:"wrap_ttl"`
+    
+    // Vault tokens carrying this policy may use the admin endpoints
+    AdminPolicy string `json:"admin_policy"`
+    
+    // mount path of the AppRole backend used in "approle" mode
+    AppRolePath string `json:"approle_path"`
+}
+
+type ConsulConfig struct {
+    // KV prefix under which registered instances are recorded
+    InstancePrefix string `json:"instance_prefix"`
+}
+
+// periodically revokes the tokens of instances that no longer exist
+type ReconcilerConfig struct {
+    // how often to check registered instances; disabled if zero
+    Interval Duration `json:"interval"`
+    
+    // log the tokens that would be revoked, without revoking them
+    DryRun bool `json:"dry_run"`
+    
+    // maximum rate of EC2 API calls
+    RequestsPerSecond float64 `json:"requests_per_second"`
+}
+
+const (
+    // the perm token is written to the cubbyhole of a limited-use temp token
+    RegistrationModeCubbyhole = "cubbyhole"
+    
+    // the perm token is response-wrapped; the wrapping token is unwrapped via
+    // sys/wrapping/unwrap
+    RegistrationModeWrap = "wrap"
+    
+    // instead of a perm token, a SecretID is generated for the role's AppRole
+    // and written with the role_id to the cubbyhole of a limited-use temp
+    // token
+    RegistrationModeAppRole = "approle"
+)
+
+const (
+    DefaultIdentityCertificates = "/etc/centralbooking/aws-identity.pem"
+    DefaultMaxLaunchAge         = 10 * time.Minute
+    DefaultRegistrationMode     = RegistrationModeCubbyhole
+    DefaultWrapTTL              = 15 * time.Second
+    DefaultInstancePrefix       = "centralbooking/instances"
+    DefaultAdminPolicy          = "centralbooking-admin"
+    DefaultAppRolePath          = "approle"
+    DefaultRequestsPerSecond    = 5
+    
+    // well beyond what the EC2 API allows, and keeps the reconciler's
+    // throttle interval from rounding down to nothing
+    MaxRequestsPerSecond        = 1000
+)
+
+// reads and validates the JSON config file at the given path
+func Load(path string) (*Config, error) {
+    cfgBytes, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    
+    cfg := &Config{}
+    err = json.Unmarshal(cfgBytes, cfg)
+    if err != nil {
+        return nil, fmt.Errorf("unable to parse %s: %s", path, err)
+    }
+    
+    err = cfg.Validate()
+    if err != nil {
+        return nil, fmt.Errorf("invalid config %s: %s", path, err)
+    }
+    
+    return cfg, nil
+}
+
+// checks the config for errors and fills in defaults
+func (self *Config) Validate() error {
+    if self.AWS.IdentityCertificates == "" {
+        self.AWS.IdentityCertificates = DefaultIdentityCertificates
+    }
+    
+    if self.AWS.MaxLaunchAge.Duration == 0 {
+        self.AWS.MaxLaunchAge.Duration = DefaultMaxLaunchAge
+    } else if self.AWS.MaxLaunchAge.Duration < 0 {
+        return errors.New("aws.max_launch_age must be positive")
+    }
+    
+    switch self.Vault.RegistrationMode {
+        case "":
+            self.Vault.RegistrationMode = DefaultRegistrationMode
+        
+        case RegistrationModeCubbyhole, RegistrationModeWrap, RegistrationModeAppRole:
+            // ok
+        
+        default:
+            return fmt.Errorf("unknown vault.registration_mode %s", self.Vault.RegistrationMode)
+    }
+    
+    if self.Vault.WrapTTL.Duration == 0 {
+        self.Vault.WrapTTL.Duration = DefaultWrapTTL
+    } else if self.Vault.WrapTTL.Duration < 0 {
+        return errors.New("vault.wrap_ttl must be positive")
+    }
+    
+    if self.Vault.AdminPolicy == "" {
+        self.Vault.AdminPolicy = DefaultAdminPolicy
+    } else if self.Vault.AdminPolicy == "root" || self.Vault.AdminPolicy == "default" {
+        // every token has "default"; root tokens are always admins
+        return fmt.Errorf("vault.admin_policy cannot be %s", self.Vault.AdminPolicy)
+    }
+    
+    self.Vault.AppRolePath = strings.Trim(self.Vault.AppRolePath, "/")
+    if self.Vault.AppRolePath == "" {
+        self.Vault.AppRolePath = DefaultAppRolePath
+    }
+    
+    self.Consul.InstancePrefix = strings.Trim(self.Consul.InstancePrefix, "/")
+    if self.Consul.InstancePrefix == "" {
+        self.Consul.InstancePrefix = DefaultInstancePrefix
+    }
+    
+    if self.Reconciler.Interval.Duration < 0 {
+        return errors.New("reconciler.interval must be positive")
+    }
+    
+    if self.Reconciler.RequestsPerSecond == 0 {
+        self.Reconciler.RequestsPerSecond = DefaultRequestsPerSecond
+    } else if self.Reconciler.RequestsPerSecond < 0 {
+        return errors.New("reconciler.requests_per_second must be positive")
+    } else if self.Reconciler.RequestsPerSecond > MaxRequestsPerSecond {
+        return fmt.Errorf("reconciler.requests_per_second must be at most %d", MaxRequestsPerSecond)
+    }
+    
+    // account names must be interchangeable with their IDs, or an instance
+    // could pick whichever name has the laxest networks and roles
+    accountNames := map[string]string{}
+    for name, id := range self.AWS.Accounts {
+        if id == "" {
+            return fmt.Errorf("no account ID for account %s", name)
+        }
+        
+        if other, ok := accountNames[id]; ok {
+            return fmt.Errorf("accounts %s and %s are both account ID %s", other, name, id)
+        }
+        accountNames[id] = name
+    }
+    
+    for id, roleARN := range self.AWS.AssumeRoles {
+        if ! strings.HasPrefix(roleARN, "arn:") {
+            return fmt.Errorf("aws.assume_roles.%s: invalid role ARN %q", id, roleARN)
+        }
+    }
+    
+    for name, env := range self.Environments {
+        err := env.validate()
+        if err != nil {
+            return fmt.Errorf("environments.%s: %s", name, err)
+        }
+        
+        for account := range env.AccountCIDRs {
+            if !self.AWS.KnownAccount(account) {
+                return fmt.Errorf("environments.%s: account_cidrs.%s: unknown account", name, account)
+            }
+        }
+        
+        // keep the parsed networks
+        self.Environments[name] = env
+    }
+    
+    for i := range self.Roles {
+        err := self.Roles[i].validate()
+        if err != nil {
+            return fmt.Errorf("roles[%d]: %s", i, err)
+        }
+        
+        // the token role's own period applies; one here would be silently
+        // ignored
+        if !self.Roles[i].Token.periodDefaulted {
+            for name, env := range self.Environments {
+                if env.TokenRole != "" && globMatch(self.Roles[i].Environment, name) {
+                    return fmt.Errorf("roles[%d]: token.period does not apply in environment %s, which uses token_role %s", i, name, env.TokenRole)
+                }
+            }
+        }
+        
+        // the role's policies are only used for tokens
+        if self.Vault.RegistrationMode == RegistrationModeAppRole {
+            if self.Roles[i].AppRole == "" {
+                return fmt.Errorf("roles[%d]: no approle", i)
+            }
+        } else if len(self.Roles[i].Policies) == 0 {
+            return fmt.Errorf("roles[%d]: no policies", i)
+        }
+        
+        // wrapped tokens don't come with a cubbyhole to put these in
+        if self.Vault.RegistrationMode == RegistrationModeWrap && self.Roles[i].HasCubbyholeEntries() {
+            return fmt.Errorf("roles[%d]: pki, consul_acl and secrets are not supported in wrap mode", i)
+        }
+    }
+    
+    return nil
+}
+
+// returns the Vault path of the named AppRole role
+func (self *VaultConfig) AppRoleRolePath(role string) string {
+    return fmt.Sprintf("auth/%s/role/%s", self.AppRolePath, role)
+}
+
+// returns the AWS account ID for the named account.  names without a mapping
+// are assumed to be account IDs already.
+func (self *AWSConfig) AccountID(name string) string {
+    if id, ok := self.Accounts[name]; ok {
+        return id
+    }
+    
+    return name
+}
+
+// returns true if instances may register with the named account.  once any
+// accounts are named, only those names may be used; an account ID would
+// otherwise sidestep the networks and roles configured for its name.
+func (self *AWSConfig) KnownAccount(name string) bool {
+    if len(self.Accounts) == 0 {
+        return true
+    }
+    
+    _, ok := self.Accounts[name]
+    return ok
+}
@@ -0,0 +1,13 @@
+package config_test
+
+import (
+    . "github.com/onsi/ginkgo"
+    . "github.com/onsi/gomega"
+
+    "testing"
+)
+
+func TestConfig(t *testing.T) {
+    RegisterFailHandler(Fail)
+    RunSpecs(t, "Config Suite")
+}
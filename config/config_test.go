@@ -0,0 +1,324 @@
+package config_test
+
+import (
+    "os"
+    "time"
+    "io/ioutil"
+    
+    "github.com/bluestatedigital/centralbooking/config"
+
+    . "github.com/onsi/ginkgo"
+    . "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config", func() {
+    var cfgFile *os.File
+    
+    writeConfig := func(contents string) string {
+        var err error
+        cfgFile, err = ioutil.TempFile("", "centralbooking-config")
+        Expect(err).To(BeNil())
+        
+        _, err = cfgFile.WriteString(contents)
+        Expect(err).To(BeNil())
+        Expect(cfgFile.Close()).To(BeNil())
+        
+        return cfgFile.Name()
+    }
+    
+    AfterEach(func() {
+        if cfgFile != nil {
+            os.Remove(cfgFile.Name())
+            cfgFile = nil
+        }
+    })
+    
+    Describe("loading", func() {
+        It("fills in defaults", func() {
+            cfg, err := config.Load(writeConfig(`{}`))
+            Expect(err).To(BeNil())
+            
+            Expect(cfg.AWS.IdentityCertificates).To(Equal(config.DefaultIdentityCertificates))
+            Expect(cfg.AWS.MaxLaunchAge.Duration).To(Equal(config.DefaultMaxLaunchAge))
+            Expect(cfg.Vault.RegistrationMode).To(Equal(config.RegistrationModeCubbyhole))
+            Expect(cfg.Vault.WrapTTL.Duration).To(Equal(config.DefaultWrapTTL))
+            Expect(cfg.Consul.InstancePrefix).To(Equal(config.DefaultInstancePrefix))
+            Expect(cfg.Vault.AdminPolicy).To(Equal(config.DefaultAdminPolicy))
+            Expect(cfg.Reconciler.Interval.Duration).To(BeZero())
+            Expect(cfg.Reconciler.RequestsPerSecond).To(Equal(float64(config.DefaultRequestsPerSecond)))
+        })
+        
+        It("parses durations", func() {
+            cfg, err := config.Load(writeConfig(`{"aws": {"max_launch_age": "5m"}}`))
+            Expect(err).To(BeNil())
+            
+            Expect(cfg.AWS.MaxLaunchAge.Duration).To(Equal(5 * time.Minute))
+        })
+        
+        It("rejects unknown registration modes", func() {
+            _, err := config.Load(writeConfig(`{"vault": {"registration_mode": "carrier-pigeon"}}`))
+            Expect(err).NotTo(BeNil())
+        })
+        
+        It("rejects the default policy as the admin policy", func() {
+            _, err := config.Load(writeConfig(`{"vault": {"admin_policy": "default"}}`))
+            Expect(err).NotTo(BeNil())
+        })
+        
+        It("rejects excessive reconciler request rates", func() {
+            _, err := config.Load(writeConfig(`{"reconciler": {"requests_per_second": 2e9}}`))
+            Expect(err).NotTo(BeNil())
+        })
+        
+        It("rejects invalid JSON", func() {
+            _, err := config.Load(writeConfig(`{`))
+            Expect(err).NotTo(BeNil())
+        })
+        
+        It("rejects roles that allow the root policy", func() {
+            _, err := config.Load(writeConfig(`{"roles": [{"role": "*", "policies": ["r*"]}]}`))
+            Expect(err).NotTo(BeNil())
+        })
+        
+        It("rejects roles with invalid patterns", func() {
+            _, err := config.Load(writeConfig(`{"roles": [{"role": "[", "policies": ["default"]}]}`))
+            Expect(err).NotTo(BeNil())
+        })
+        
+        It("rejects token roles that aren't a plain name", func() {
+            _, err := config.Load(writeConfig(`{"environments": {"dev": {"token_role": "../create-orphan"}}, "roles": [{"policies": ["default"]}]}`))
+            Expect(err).NotTo(BeNil())
+        })
+        
+        It("rejects token periods for environments with a token role", func() {
+            _, err := config.Load(writeConfig(`{"environments": {"dev": {"token_role": "instance-dev"}, "prod": {}}, "roles": [{"environment": "prod", "policies": ["default"], "token": {"period": "24h"}}, {"environment": "d*", "policies": ["default"], "token": {"period": "24h"}}]}`))
+            Expect(err).To(MatchError(ContainSubstring("roles[1]: token.period does not apply in environment dev")))
+        })
+        
+        It("requires an approle for every role in approle mode", func() {
+            _, err := config.Load(writeConfig(`{"vault": {"registration_mode": "approle"}, "roles": [{"role": "web", "approle": "web"}, {"role": "*", "policies": ["default"]}]}`))
+            Expect(err).To(MatchError(ContainSubstring("roles[1]: no approle")))
+        })
+        
+        It("doesn't require policies in approle mode", func() {
+            cfg, err := config.Load(writeConfig(`{"vault": {"registration_mode": "approle"}, "roles": [{"role": "*", "approle": "web"}]}`))
+            Expect(err).To(BeNil())
+            Expect(cfg.Vault.AppRoleRolePath("web")).To(Equal("auth/approle/role/web"))
+        })
+        
+        It("parses certificate name templates", func() {
+            cfg, err := config.Load(writeConfig(`{"roles": [{"policies": ["default"], "pki": {"role": "consul", "common_name": "{{.InstanceID}}.node.{{.Region}}.consul"}}]}`))
+            Expect(err).To(BeNil())
+            Expect(cfg.Roles[0].PKI.IssuePath()).To(Equal("pki/issue/consul"))
+            
+            commonName, _, _, err := cfg.Roles[0].PKI.Render(&config.InstanceVars{ InstanceID: "i-04c9c4c4", Region: "us-east-1" })
+            Expect(err).To(BeNil())
+            Expect(commonName).To(Equal("i-04c9c4c4.node.us-east-1.consul"))
+        })
+        
+        It("rejects certificate templates with unknown fields", func() {
+            _, err := config.Load(writeConfig(`{"roles": [{"policies": ["default"], "pki": {"role": "consul", "common_name": "{{.Hostname}}.consul"}}]}`))
+            Expect(err).To(MatchError(ContainSubstring("pki.common_name")))
+        })
+        
+        It("rejects certificates in wrap mode", func() {
+            _, err := config.Load(writeConfig(`{"vault": {"registration_mode": "wrap"}, "roles": [{"policies": ["default"], "pki": {"role": "consul", "common_name": "consul"}}]}`))
+            Expect(err).NotTo(BeNil())
+        })
+        
+        It("requires consul acl rules", func() {
+            _, err := config.Load(writeConfig(`{"roles": [{"policies": ["default"], "consul_acl": {}}]}`))
+            Expect(err).To(MatchError(ContainSubstring("consul_acl.rules")))
+        })
+        
+        It("escapes values in consul acl rules", func() {
+            cfg, err := config.Load(writeConfig(`{"roles": [{"policies": ["default"], "consul_acl": {"rules": "key \"service/{{.Role}}/\" { policy = \"write\" }"}}]}`))
+            Expect(err).To(BeNil())
+            
+            rules, err := cfg.Roles[0].ConsulACL.Render(&config.InstanceVars{ Role: `x" { policy = "write" } key "` })
+            Expect(err).To(BeNil())
+            Expect(rules).To(Equal(`key "service/x\" { policy = \"write\" } key \"/" { policy = "write" }`))
+        })
+        
+        It("rejects secrets named like centralbooking's own entries", func() {
+            _, err := config.Load(writeConfig(`{"roles": [{"policies": ["default"], "secrets": [{"name": "perm", "path": "secret/perm"}]}]}`))
+            Expect(err).To(MatchError(ContainSubstring("roles[0]: secrets[0]")))
+        })
+        
+        It("rejects duplicate secret names", func() {
+            _, err := config.Load(writeConfig(`{"roles": [{"policies": ["default"], "secrets": [{"name": "gossip", "path": "secret/a"}, {"name": "gossip", "path": "secret/b"}]}]}`))
+            Expect(err).To(MatchError(ContainSubstring("secrets[1]: duplicate")))
+        })
+        
+        It("fills in token defaults for roles", func() {
+            cfg, err := config.Load(writeConfig(`{"roles": [{"role": "*", "policies": ["default"], "token": {"explicit_max_ttl": "4h"}}]}`))
+            Expect(err).To(BeNil())
+            
+            token := cfg.Roles[0].Token
+            Expect(token.Period.Duration).To(Equal(config.DefaultTokenPeriod))
+            Expect(token.ExplicitMaxTTL.Duration).To(Equal(4 * time.Hour))
+            Expect(token.TempTTL.Duration).To(Equal(config.DefaultTempTokenTTL))
+            Expect(token.TempNumUses).To(Equal(config.DefaultTempTokenNumUses))
+        })
+        
+        It("rejects temp tokens with too few uses", func() {
+            _, err := config.Load(writeConfig(`{"roles": [{"role": "*", "policies": ["default"], "token": {"temp_num_uses": 1}}]}`))
+            Expect(err).NotTo(BeNil())
+        })
+        
+        It("rejects negative token lifetimes", func() {
+            _, err := config.Load(writeConfig(`{"roles": [{"role": "*", "policies": ["default"], "token": {"period": "-1h"}}]}`))
+            Expect(err).NotTo(BeNil())
+        })
+        
+        It("rejects roles without policies", func() {
+            _, err := config.Load(writeConfig(`{"roles": [{"role": "*"}]}`))
+            Expect(err).NotTo(BeNil())
+        })
+    })
+    
+    Describe("accounts", func() {
+        aws := config.AWSConfig{
+            Accounts: map[string]string{
+                "gen": "123456789012",
+            },
+        }
+        
+        It("maps account names to IDs", func() {
+            Expect(aws.AccountID("gen")).To(Equal("123456789012"))
+        })
+        
+        It("passes through unmapped accounts", func() {
+            Expect(aws.AccountID("210987654321")).To(Equal("210987654321"))
+        })
+        
+        It("only knows named accounts once any are named", func() {
+            Expect(aws.KnownAccount("gen")).To(BeTrue())
+            Expect(aws.KnownAccount("123456789012")).To(BeFalse())
+            
+            Expect((&config.AWSConfig{}).KnownAccount("123456789012")).To(BeTrue())
+        })
+        
+        It("rejects accounts sharing an ID", func() {
+            _, err := config.Load(writeConfig(`{"aws": {"accounts": {"gen": "123456789012", "web": "123456789012"}}}`))
+            Expect(err).NotTo(BeNil())
+        })
+        
+        It("rejects networks for unknown accounts", func() {
+            _, err := config.Load(writeConfig(`{
+                "aws": {"accounts": {"gen": "123456789012"}},
+                "environments": {"prod": {"account_cidrs": {"123456789012": ["10.10.8.0/24"]}}}
+            }`))
+            Expect(err).To(MatchError(ContainSubstring("account_cidrs.123456789012: unknown account")))
+        })
+        
+        It("rejects invalid assume role ARNs", func() {
+            _, err := config.Load(writeConfig(`{"aws": {"assume_roles": {"210987654321": "centralbooking"}}}`))
+            Expect(err).NotTo(BeNil())
+        })
+    })
+    
+    Describe("environment networks", func() {
+        var cfg *config.Config
+        
+        BeforeEach(func() {
+            var err error
+            cfg, err = config.Load(writeConfig(`{
+                "environments": {
+                    "prod": {
+                        "allowed_cidrs": ["10.10.0.0/16", "10.20.0.0/16"],
+                        "account_cidrs": {
+                            "gen": ["10.10.8.0/24"]
+                        }
+                    },
+                    "dev": {}
+                }
+            }`))
+            Expect(err).To(BeNil())
+        })
+        
+        environment := func(name string) config.EnvironmentConfig {
+            envCfg, ok := cfg.Environment(name)
+            Expect(ok).To(BeTrue())
+            
+            return envCfg
+        }
+        
+        It("allows addresses in the environment's networks", func() {
+            Expect(environment("prod").AllowsAddress("web", "10.20.1.1")).To(BeTrue())
+            Expect(environment("prod").AllowsAddress("web", "10.112.16.35")).To(BeFalse())
+        })
+        
+        It("uses the account's networks instead, if any", func() {
+            Expect(environment("prod").AllowsAddress("gen", "10.10.8.1")).To(BeTrue())
+            Expect(environment("prod").AllowsAddress("gen", "10.10.9.1")).To(BeFalse())
+        })
+        
+        It("rejects unparseable addresses", func() {
+            Expect(environment("prod").AllowsAddress("web", "")).To(BeFalse())
+        })
+        
+        It("allows any address without networks", func() {
+            Expect(environment("dev").AllowsAddress("gen", "192.168.1.1")).To(BeTrue())
+        })
+        
+        It("rejects invalid networks", func() {
+            _, err := config.Load(writeConfig(`{"environments": {"prod": {"allowed_cidrs": ["10.10.0.0"]}}}`))
+            Expect(err).NotTo(BeNil())
+        })
+        
+        It("rejects environments that aren't configured", func() {
+            _, ok := cfg.Environment("staging")
+            Expect(ok).To(BeFalse())
+        })
+        
+        It("allows any environment if none are configured", func() {
+            cfg, err := config.Load(writeConfig(`{}`))
+            Expect(err).To(BeNil())
+            
+            _, ok := cfg.Environment("staging")
+            Expect(ok).To(BeTrue())
+        })
+    })
+    
+    Describe("roles", func() {
+        cfg := &config.Config{
+            Roles: []config.RoleConfig{
+                config.RoleConfig{
+                    Environment: "prod",
+                    Role:        "cluster-server",
+                    Policies:    []string{ "cluster-server" },
+                },
+                config.RoleConfig{
+                    Environment: "*",
+                    Provider:    "aws",
+                    Account:     "gen",
+                    Role:        "cluster-*",
+                    Policies:    []string{ "instance-management", "consul-*" },
+                },
+            },
+        }
+        
+        It("returns the first matching role", func() {
+            Expect(cfg.FindRole("prod", "aws", "gen", "cluster-server")).To(Equal(&cfg.Roles[0]))
+            Expect(cfg.FindRole("dev", "aws", "gen", "cluster-server")).To(Equal(&cfg.Roles[1]))
+        })
+        
+        It("returns nil if no role matches", func() {
+            Expect(cfg.FindRole("dev", "aws", "gen", "web")).To(BeNil())
+            Expect(cfg.FindRole("dev", "gce", "gen", "cluster-server")).To(BeNil())
+        })
+        
+        It("allows policies matching patterns", func() {
+            role := cfg.Roles[1]
+            
+            Expect(role.AllowsPolicy("instance-management")).To(BeTrue())
+            Expect(role.AllowsPolicy("consul-agent")).To(BeTrue())
+            Expect(role.AllowsPolicy("secret-admin")).To(BeFalse())
+        })
+        
+        It("defaults to the literal policies", func() {
+            Expect(cfg.Roles[1].DefaultPolicies()).To(Equal([]string{ "instance-management" }))
+        })
+    })
+})
@@ -0,0 +1,61 @@
+package config
+
+import (
+    "fmt"
+    "errors"
+    "text/template"
+    "encoding/json"
+)
+
+// a Consul ACL token created for the instance
+type ConsulACLConfig struct {
+    // the token's rules, in HCL or JSON; a template rendered with the
+    // instance's InstanceVars, like `node "{{.InstanceID}}" { policy = "write" }`
+    Rules string `json:"rules"`
+    
+    // parsed from the above by validate
+    rules *template.Template
+}
+
+func (self *ConsulACLConfig) validate() error {
+    var err error
+    
+    if self.Rules == "" {
+        return errors.New("consul_acl.rules is required")
+    }
+    
+    self.rules, err = parseTemplate(self.Rules)
+    if err != nil {
+        return fmt.Errorf("consul_acl.rules: %s", err)
+    }
+    
+    return nil
+}
+
+// renders the token's rules for the instance.  the instance's values are
+// escaped for use inside the rules' quoted strings, so they can't close the
+// string and add rules of their own.
+func (self *ConsulACLConfig) Render(vars *InstanceVars) (string, error) {
+    escaped := *vars
+    for _, field := range []*string{
+        &escaped.Environment,
+        &escaped.Provider,
+        &escaped.Account,
+        &escaped.Region,
+        &escaped.InstanceID,
+        &escaped.Role,
+        &escaped.Address,
+    } {
+        *field = escapeString(*field)
+    }
+    
+    return renderTemplate(self.rules, &escaped)
+}
+
+// escapes s for use inside a double-quoted string.  JSON's escapes are also
+// understood by HCL.
+func escapeString(s string) string {
+    quoted, _ := json.Marshal(s)
+    
+    return string(quoted[1:len(quoted) - 1])
+}
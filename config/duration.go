@@ -0,0 +1,26 @@
+package config
+
+import (
+    "time"
+    "encoding/json"
+)
+
+// a time.Duration that is represented in JSON as a string like "10m"
+type Duration struct {
+    time.Duration
+}
+
+func (self *Duration) UnmarshalJSON(data []byte) error {
+    var s string
+    err := json.Unmarshal(data, &s)
+    if err != nil {
+        return err
+    }
+    
+    self.Duration, err = time.ParseDuration(s)
+    return err
+}
+
+func (self Duration) MarshalJSON() ([]byte, error) {
+    return json.Marshal(self.String())
+}
@@ -0,0 +1,109 @@
+package config
+
+import (
+    "net"
+    "fmt"
+    "strings"
+)
+
+// settings that apply to every instance registering in an environment
+type EnvironmentConfig struct {
+    // Consul datacenter the environment's instances belong to; defaults to
+    // the datacenter of centralbooking's local Consul agent
+    Datacenter string `json:"datacenter"`
+    
+    // Consul datacenter that is authoritative for ACLs
+    ACLDatacenter string `json:"acl_datacenter"`
+    
+    // Vault token role perm tokens are created through; if empty they're
+    // created as orphans via auth/token/create, which needs a sudo token
+    TokenRole string `json:"token_role"`
+    
+    // registrations must come from one of these networks; any address is
+    // allowed if empty
+    AllowedCIDRs []string `json:"allowed_cidrs"`
+    
+    // per-account networks, keyed by account as given in the registration
+    // request; replaces AllowedCIDRs for that account
+    AccountCIDRs map[string][]string `json:"account_cidrs"`
+    
+    // parsed from the above by validate
+    allowedNets []*net.IPNet
+    accountNets map[string][]*net.IPNet
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+    nets := make([]*net.IPNet, 0, len(cidrs))
+    for _, cidr := range cidrs {
+        _, ipNet, err := net.ParseCIDR(cidr)
+        if err != nil {
+            return nil, err
+        }
+        
+        nets = append(nets, ipNet)
+    }
+    
+    return nets, nil
+}
+
+func (self *EnvironmentConfig) validate() error {
+    var err error
+    
+    if strings.Contains(self.TokenRole, "/") {
+        return fmt.Errorf("token_role: invalid role name %q", self.TokenRole)
+    }
+    
+    self.allowedNets, err = parseCIDRs(self.AllowedCIDRs)
+    if err != nil {
+        return fmt.Errorf("allowed_cidrs: %s", err)
+    }
+    
+    self.accountNets = map[string][]*net.IPNet{}
+    for account, cidrs := range self.AccountCIDRs {
+        if len(cidrs) == 0 {
+            // would otherwise silently allow everything
+            return fmt.Errorf("account_cidrs.%s: no networks", account)
+        }
+        
+        self.accountNets[account], err = parseCIDRs(cidrs)
+        if err != nil {
+            return fmt.Errorf("account_cidrs.%s: %s", account, err)
+        }
+    }
+    
+    return nil
+}
+
+// returns true if an instance in the account may register from the address
+func (self EnvironmentConfig) AllowsAddress(account string, addr string) bool {
+    nets, ok := self.accountNets[account]
+    if !ok {
+        nets = self.allowedNets
+    }
+    
+    if len(nets) == 0 {
+        return true
+    }
+    
+    ip := net.ParseIP(addr)
+    if ip == nil {
+        return false
+    }
+    
+    for _, ipNet := range nets {
+        if ipNet.Contains(ip) {
+            return true
+        }
+    }
+    
+    return false
+}
+
+// returns the config for the named environment.  if any environments are
+// configured, instances may only register in one of them and false is
+// returned for any other; otherwise every environment gets the zero value.
+func (self *Config) Environment(name string) (EnvironmentConfig, bool) {
+    envCfg, ok := self.Environments[name]
+    
+    return envCfg, ok || len(self.Environments) == 0
+}
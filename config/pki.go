@@ -0,0 +1,97 @@
+package config
+
+import (
+    "fmt"
+    "errors"
+    "strings"
+    "text/template"
+)
+
+// a TLS certificate issued to the instance by a Vault PKI backend.  the names
+// are templates rendered with the instance's InstanceVars, like
+// "{{.InstanceID}}.node.{{.Region}}.consul".
+type PKIConfig struct {
+    // mount path of the PKI backend
+    Mount      string   `json:"mount"`
+    
+    // PKI role to issue the certificate with
+    Role       string   `json:"role"`
+    
+    CommonName string   `json:"common_name"`
+    AltNames   []string `json:"alt_names"`
+    IPSANs     []string `json:"ip_sans"`
+    
+    // the role's default TTL is used if zero
+    TTL        Duration `json:"ttl"`
+    
+    // parsed from the above by validate
+    commonName *template.Template
+    altNames   []*template.Template
+    ipSANs     []*template.Template
+}
+
+const DefaultPKIMount = "pki"
+
+func (self *PKIConfig) validate() error {
+    var err error
+    
+    self.Mount = strings.Trim(self.Mount, "/")
+    if self.Mount == "" {
+        self.Mount = DefaultPKIMount
+    }
+    
+    if self.Role == "" {
+        return errors.New("pki.role is required")
+    }
+    
+    if self.CommonName == "" {
+        return errors.New("pki.common_name is required")
+    }
+    
+    if self.TTL.Duration < 0 {
+        return errors.New("pki.ttl must be positive")
+    }
+    
+    self.commonName, err = parseTemplate(self.CommonName)
+    if err != nil {
+        return fmt.Errorf("pki.common_name: %s", err)
+    }
+    
+    self.altNames, err = parseTemplates(self.AltNames)
+    if err != nil {
+        return fmt.Errorf("pki.alt_names: %s", err)
+    }
+    
+    self.ipSANs, err = parseTemplates(self.IPSANs)
+    if err != nil {
+        return fmt.Errorf("pki.ip_sans: %s", err)
+    }
+    
+    return nil
+}
+
+// the path certificates are issued from
+func (self *PKIConfig) IssuePath() string {
+    return fmt.Sprintf("%s/issue/%s", self.Mount, self.Role)
+}
+
+// renders the certificate's common name, alternate names and IP SANs for the
+// instance
+func (self *PKIConfig) Render(vars *InstanceVars) (string, []string, []string, error) {
+    commonName, err := renderTemplate(self.commonName, vars)
+    if err != nil {
+        return "", nil, nil, err
+    }
+    
+    altNames, err := renderTemplates(self.altNames, vars)
+    if err != nil {
+        return "", nil, nil, err
+    }
+    
+    ipSANs, err := renderTemplates(self.ipSANs, vars)
+    if err != nil {
+        return "", nil, nil, err
+    }
+    
+    return commonName, altNames, ipSANs, nil
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+    "fmt"
+    "path"
+    "strings"
+)
+
+// maps instances to the Vault policies they may be granted.  environment,
+// provider, account and role are glob patterns (as in path.Match); an empty
+// pattern matches anything.
+type RoleConfig struct {
+    Environment string   `json:"environment"`
+    Provider    string   `json:"provider"`
+    Account     string   `json:"account"`
+    Role        string   `json:"role"`
+    
+    // policies the instance may request.  these may also be glob patterns;
+    // only the literal policies are granted when the instance requests none.
+    Policies    []string `json:"policies"`
+    
+    // AppRole role whose SecretIDs are issued to the instance in "approle"
+    // registration mode, where the AppRole's own policies apply instead of
+    // Policies
+    AppRole     string   `json:"approle"`
+    
+    Token       TokenConfig `json:"token"`
+    
+    // TLS certificate to issue to the instance, if any
+    PKI         *PKIConfig  `json:"pki"`
+    
+    // signs the instance's SSH host key, if it provides one
+    SSH         *SSHConfig  `json:"ssh"`
+    
+    // Consul ACL token to create for the instance, if any
+    ConsulACL   *ConsulACLConfig `json:"consul_acl"`
+    
+    // Vault secrets to deliver next to the perm token
+    Secrets     []SecretConfig `json:"secrets"`
+}
+
+func globMatch(pattern, name string) bool {
+    if pattern == "" {
+        return true
+    }
+    
+    matched, _ := path.Match(pattern, name)
+    return matched
+}
+
+func isGlob(pattern string) bool {
+    return strings.ContainsAny(pattern, `*?[\`)
+}
+
+func (self *RoleConfig) validate() error {
+    for _, pattern := range []string{self.Environment, self.Provider, self.Account, self.Role} {
+        if _, err := path.Match(pattern, ""); err != nil {
+            return fmt.Errorf("invalid pattern %q", pattern)
+        }
+    }
+    
+    if strings.Contains(self.AppRole, "/") {
+        return fmt.Errorf("invalid approle %q", self.AppRole)
+    }
+    
+    for _, policy := range self.Policies {
+        if _, err := path.Match(policy, ""); err != nil {
+            return fmt.Errorf("invalid policy pattern %q", policy)
+        }
+        
+        if globMatch(policy, "root") {
+            return fmt.Errorf("policy %q allows root", policy)
+        }
+    }
+    
+    if self.PKI != nil {
+        err := self.PKI.validate()
+        if err != nil {
+            return err
+        }
+    }
+    
+    if self.SSH != nil {
+        err := self.SSH.validate()
+        if err != nil {
+            return err
+        }
+    }
+    
+    if self.ConsulACL != nil {
+        err := self.ConsulACL.validate()
+        if err != nil {
+            return err
+        }
+    }
+    
+    names := map[string]bool{}
+    for i := range self.Secrets {
+        err := self.Secrets[i].validate()
+        if err != nil {
+            return fmt.Errorf("secrets[%d]: %s", i, err)
+        }
+        
+        if names[self.Secrets[i].Name] {
+            return fmt.Errorf("secrets[%d]: duplicate name %q", i, self.Secrets[i].Name)
+        }
+        
+        names[self.Secrets[i].Name] = true
+    }
+    
+    return self.Token.validate()
+}
+
+// true if the role delivers anything besides the credentials, which requires
+// the temp token's cubbyhole
+func (self *RoleConfig) HasCubbyholeEntries() bool {
+    return self.PKI != nil || self.ConsulACL != nil || len(self.Secrets) > 0
+}
+
+// true if the role config applies to the given instance
+func (self *RoleConfig) Matches(env, provider, account, role string) bool {
+    return globMatch(self.Environment, env) &&
+        globMatch(self.Provider, provider) &&
+        globMatch(self.Account, account) &&
+        globMatch(self.Role, role)
+}
+
+// true if the policy may be granted
+func (self *RoleConfig) AllowsPolicy(policy string) bool {
+    for _, allowed := range self.Policies {
+        if globMatch(allowed, policy) {
+            return true
+        }
+    }
+    
+    return false
+}
+
+// the policies granted when the instance doesn't request any
+func (self *RoleConfig) DefaultPolicies() []string {
+    policies := make([]string, 0, len(self.Policies))
+    
+    for _, policy := range self.Policies {
+        if ! isGlob(policy) {
+            policies = append(policies, policy)
+        }
+    }
+    
+    return policies
+}
+
+// returns the first role config matching the instance, or nil
+func (self *Config) FindRole(env, provider, account, role string) *RoleConfig {
+    for i := range self.Roles {
+        if self.Roles[i].Matches(env, provider, account, role) {
+            return &self.Roles[i]
+        }
+    }
+    
+    return nil
+}
@@ -0,0 +1,53 @@
+package config
+
+import (
+    "fmt"
+    "errors"
+    "strings"
+    "text/template"
+)
+
+// a Vault secret copied into the temp token's cubbyhole, at cubbyhole/<name>
+type SecretConfig struct {
+    Name string `json:"name"`
+    
+    // a template rendered with the instance's InstanceVars, like
+    // "secret/consul/{{.Environment}}/gossip"
+    Path string `json:"path"`
+    
+    // parsed from the above by validate
+    path *template.Template
+}
+
+// cubbyhole entries centralbooking writes itself
+var reservedSecretNames = []string{"perm", "approle", "pki", "consul_acl"}
+
+func (self *SecretConfig) validate() error {
+    var err error
+    
+    if self.Name == "" || strings.Contains(self.Name, "/") {
+        return fmt.Errorf("invalid name %q", self.Name)
+    }
+    
+    for _, reserved := range reservedSecretNames {
+        if self.Name == reserved {
+            return fmt.Errorf("name %q is reserved", self.Name)
+        }
+    }
+    
+    if self.Path == "" {
+        return errors.New("path is required")
+    }
+    
+    self.path, err = parseTemplate(self.Path)
+    if err != nil {
+        return fmt.Errorf("path: %s", err)
+    }
+    
+    return nil
+}
+
+// renders the secret's path for the instance
+func (self *SecretConfig) Render(vars *InstanceVars) (string, error) {
+    return renderTemplate(self.path, vars)
+}
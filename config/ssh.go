@@ -0,0 +1,69 @@
+package config
+
+import (
+    "fmt"
+    "errors"
+    "strings"
+    "text/template"
+)
+
+// signs the instance's SSH host key with a Vault SSH CA
+type SSHConfig struct {
+    // mount path of the SSH backend
+    Mount      string   `json:"mount"`
+    
+    // SSH role to sign the host key with; must allow host certificates
+    Role       string   `json:"role"`
+    
+    // templates rendered with the instance's InstanceVars
+    Principals []string `json:"principals"`
+    
+    // the role's default TTL is used if zero
+    TTL        Duration `json:"ttl"`
+    
+    // parsed from the above by validate
+    principals []*template.Template
+}
+
+const DefaultSSHMount = "ssh"
+
+// the instance ID, private IP and role
+var DefaultSSHPrincipals = []string{"{{.InstanceID}}", "{{.Address}}", "{{.Role}}"}
+
+func (self *SSHConfig) validate() error {
+    var err error
+    
+    self.Mount = strings.Trim(self.Mount, "/")
+    if self.Mount == "" {
+        self.Mount = DefaultSSHMount
+    }
+    
+    if self.Role == "" {
+        return errors.New("ssh.role is required")
+    }
+    
+    if len(self.Principals) == 0 {
+        self.Principals = DefaultSSHPrincipals
+    }
+    
+    if self.TTL.Duration < 0 {
+        return errors.New("ssh.ttl must be positive")
+    }
+    
+    self.principals, err = parseTemplates(self.Principals)
+    if err != nil {
+        return fmt.Errorf("ssh.principals: %s", err)
+    }
+    
+    return nil
+}
+
+// the path host keys are signed at
+func (self *SSHConfig) SignPath() string {
+    return fmt.Sprintf("%s/sign/%s", self.Mount, self.Role)
+}
+
+// renders the certificate's principals for the instance
+func (self *SSHConfig) Render(vars *InstanceVars) ([]string, error) {
+    return renderTemplates(self.principals, vars)
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+    "bytes"
+    "text/template"
+)
+
+// what's known about a registering instance, for rendering templates in the
+// config
+type InstanceVars struct {
+    Environment string
+    Provider    string
+    Account     string
+    Region      string
+    InstanceID  string
+    Role        string
+    
+    // the instance's private IP
+    Address     string
+}
+
+// parses a template, and renders it once so references to unknown fields
+// are caught now rather than during registration
+func parseTemplate(text string) (*template.Template, error) {
+    tmpl, err := template.New("").Parse(text)
+    if err != nil {
+        return nil, err
+    }
+    
+    _, err = renderTemplate(tmpl, &InstanceVars{})
+    if err != nil {
+        return nil, err
+    }
+    
+    return tmpl, nil
+}
+
+func renderTemplate(tmpl *template.Template, vars *InstanceVars) (string, error) {
+    var buf bytes.Buffer
+    
+    err := tmpl.Execute(&buf, vars)
+    if err != nil {
+        return "", err
+    }
+    
+    return buf.String(), nil
+}
+
+func parseTemplates(texts []string) ([]*template.Template, error) {
+    tmpls := make([]*template.Template, 0, len(texts))
+    for _, text := range texts {
+        tmpl, err := parseTemplate(text)
+        if err != nil {
+            return nil, err
+        }
+        
+        tmpls = append(tmpls, tmpl)
+    }
+    
+    return tmpls, nil
+}
+
+func renderTemplates(tmpls []*template.Template, vars *InstanceVars) ([]string, error) {
+    rendered := make([]string, 0, len(tmpls))
+    for _, tmpl := range tmpls {
+        text, err := renderTemplate(tmpl, vars)
+        if err != nil {
+            return nil, err
+        }
+        
+        rendered = append(rendered, text)
+    }
+    
+    return rendered, nil
+}
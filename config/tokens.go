@@ -0,0 +1,61 @@
+package config
+
+import (
+    "time"
+    "errors"
+)
+
+// lifetimes of the tokens created for an instance
+type TokenConfig struct {
+    // the perm token is periodic, and lives as long as it's renewed within
+    // this period
+    Period Duration `json:"period"`
+    
+    // hard limit on the perm token's lifetime, regardless of renewal; none if
+    // zero
+    ExplicitMaxTTL Duration `json:"explicit_max_ttl"`
+    
+    // lifetime of the temp token used to retrieve the perm token
+    TempTTL Duration `json:"temp_ttl"`
+    
+    // number of times the temp token may be used.  centralbooking uses it once
+    // to write the perm token to the cubbyhole, and the instance once to read
+    // it back.
+    TempNumUses int `json:"temp_num_uses"`
+    
+    // true if validate filled in the default Period
+    periodDefaulted bool
+}
+
+const (
+    DefaultTokenPeriod      = 72 * time.Hour
+    DefaultTempTokenTTL     = 15 * time.Second
+    DefaultTempTokenNumUses = 2
+)
+
+func (self *TokenConfig) validate() error {
+    if self.Period.Duration == 0 {
+        self.Period.Duration = DefaultTokenPeriod
+        self.periodDefaulted = true
+    } else if self.Period.Duration < time.Second {
+        return errors.New("token.period must be at least 1s")
+    }
+    
+    if self.ExplicitMaxTTL.Duration < 0 {
+        return errors.New("token.explicit_max_ttl must be positive")
+    }
+    
+    if self.TempTTL.Duration == 0 {
+        self.TempTTL.Duration = DefaultTempTokenTTL
+    } else if self.TempTTL.Duration < time.Second {
+        return errors.New("token.temp_ttl must be at least 1s")
+    }
+    
+    if self.TempNumUses == 0 {
+        self.TempNumUses = DefaultTempTokenNumUses
+    } else if self.TempNumUses < DefaultTempTokenNumUses {
+        return errors.New("token.temp_num_uses must be at least 2")
+    }
+    
+    return nil
+}
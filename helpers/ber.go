@@ -0,0 +1,185 @@
+package helpers
+
+import (
+    "errors"
+)
+
+// a single BER-encoded element
+type berElement struct {
+    identifier  []byte
+    constructed bool
+    content     []byte
+    children    []*berElement
+}
+
+// PKCS7 signatures nest no more than a dozen or so levels deep; this stops
+// crafted input from recursing until the stack overflows
+const maxBERDepth = 32
+
+// converts BER-encoded data to DER, which is all encoding/asn1 understands.
+// the PKCS7 signatures returned by the EC2 metadata service use
+// indefinite-length encoding and constructed octet strings.  set contents are
+// not re-sorted.
+func berToDER(ber []byte) ([]byte, error) {
+    elem, rest, err := parseBER(ber, 0)
+    if err != nil {
+        return nil, err
+    }
+    
+    if len(rest) > 0 {
+        return nil, errors.New("ber: trailing data")
+    }
+    
+    return elem.encode(), nil
+}
+
+func parseBER(data []byte, depth int) (*berElement, []byte, error) {
+    if depth > maxBERDepth {
+        return nil, nil, errors.New("ber: elements nested too deeply")
+    }
+    
+    if len(data) < 2 {
+        return nil, nil, errors.New("ber: truncated element")
+    }
+    
+    // identifier octets; high tag numbers continue while bit 8 is set
+    idLen := 1
+    if data[0] & 0x1f == 0x1f {
+        for {
+            if idLen >= len(data) {
+                return nil, nil, errors.New("ber: truncated tag")
+            }
+            
+            idLen++
+            if data[idLen - 1] & 0x80 == 0 {
+                break
+            }
+        }
+    }
+    
+    elem := &berElement{
+        identifier:  data[:idLen],
+        constructed: data[0] & 0x20 != 0,
+    }
+    
+    if idLen >= len(data) {
+        return nil, nil, errors.New("ber: truncated length")
+    }
+    
+    data = data[idLen:]
+    lenByte := data[0]
+    data = data[1:]
+    
+    if lenByte == 0x80 {
+        // indefinite length; children until end-of-contents
+        if ! elem.constructed {
+            return nil, nil, errors.New("ber: indefinite length for primitive element")
+        }
+        
+        for {
+            if len(data) < 2 {
+                return nil, nil, errors.New("ber: missing end-of-contents")
+            }
+            
+            if data[0] == 0 && data[1] == 0 {
+                return elem, data[2:], nil
+            }
+            
+            child, rest, err := parseBER(data, depth + 1)
+            if err != nil {
+                return nil, nil, err
+            }
+            
+            elem.children = append(elem.children, child)
+            data = rest
+        }
+    }
+    
+    length := int(lenByte)
+    if lenByte & 0x80 != 0 {
+        numBytes := int(lenByte & 0x7f)
+        if numBytes > 4 || numBytes > len(data) {
+            return nil, nil, errors.New("ber: invalid length")
+        }
+        
+        length = 0
+        for _, b := range data[:numBytes] {
+            length = length << 8 | int(b)
+        }
+        
+        data = data[numBytes:]
+    }
+    
+    if length < 0 || length > len(data) {
+        return nil, nil, errors.New("ber: element exceeds available data")
+    }
+    
+    content := data[:length]
+    rest := data[length:]
+    
+    if ! elem.constructed {
+        elem.content = content
+        return elem, rest, nil
+    }
+    
+    for len(content) > 0 {
+        child, remaining, err := parseBER(content, depth + 1)
+        if err != nil {
+            return nil, nil, err
+        }
+        
+        elem.children = append(elem.children, child)
+        content = remaining
+    }
+    
+    return elem, rest, nil
+}
+
+// concatenates the primitive content of this element and all descendants
+func (self *berElement) flatten() []byte {
+    if ! self.constructed {
+        return self.content
+    }
+    
+    var flat []byte
+    for _, child := range self.children {
+        flat = append(flat, child.flatten()...)
+    }
+    
+    return flat
+}
+
+func (self *berElement) encode() []byte {
+    identifier := self.identifier
+    var content []byte
+    
+    if self.constructed && len(identifier) == 1 && identifier[0] == 0x24 {
+        // constructed octet strings are primitive in DER
+        identifier = []byte{0x04}
+        content = self.flatten()
+    } else if self.constructed {
+        for _, child := range self.children {
+            content = append(content, child.encode()...)
+        }
+    } else {
+        content = self.content
+    }
+    
+    encoded := append([]byte{}, identifier...)
+    encoded = append(encoded, derLength(len(content))...)
+    
+    return append(encoded, content...)
+}
+
+func derLength(length int) []byte {
+    if length < 0x80 {
+        return []byte{byte(length)}
+    }
+    
+    var lenBytes []byte
+    for l := length; l > 0; l >>= 8 {
+        lenBytes = append([]byte{byte(l)}, lenBytes...)
+    }
+    
+    return append([]byte{0x80 | byte(len(lenBytes))}, lenBytes...)
+}
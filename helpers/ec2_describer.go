@@ -0,0 +1,113 @@
+package helpers
+
+import (
+    "fmt"
+    "sync"
+    
+    "github.com/bluestatedigital/centralbooking/interfaces"
+    
+    "github.com/aws/aws-sdk-go/aws"
+    "github.com/aws/aws-sdk-go/aws/session"
+    "github.com/aws/aws-sdk-go/aws/credentials"
+    "github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+    "github.com/aws/aws-sdk-go/service/ec2"
+    "github.com/aws/aws-sdk-go/service/sts"
+)
+
+// describes EC2 instances in any region of the accounts it can reach.  an
+// account with a role is described by assuming that role; otherwise the
+// default credential chain is used.  either way the credentials must belong to
+// the account being described; with another account's credentials none of its
+// instances would be found.
+type EC2Describer struct {
+    session     *session.Session
+    endpoint    string
+    stsEndpoint string
+    roleARNs    map[string]string
+    
+    lock        sync.Mutex
+    clients     map[string]*ec2.EC2
+}
+
+// endpoint and stsEndpoint override the regional EC2 and STS endpoints if not
+// empty.  roleARNs maps account IDs to the role to assume in each.
+func NewEC2Describer(endpoint string, stsEndpoint string, roleARNs map[string]string) (interfaces.EC2Describer, error) {
+    sess, err := session.NewSession()
+    if err != nil {
+        return nil, err
+    }
+    
+    return &EC2Describer{
+        session:     sess,
+        endpoint:    endpoint,
+        stsEndpoint: stsEndpoint,
+        roleARNs:    roleARNs,
+        clients:     make(map[string]*ec2.EC2),
+    }, nil
+}
+
+// returns the account the given credentials belong to, or that of the default
+// credential chain if nil
+func (self *EC2Describer) callerAccount(region string, creds *credentials.Credentials) (string, error) {
+    cfg := aws.NewConfig().WithRegion(region)
+    if self.stsEndpoint != "" {
+        cfg = cfg.WithEndpoint(self.stsEndpoint)
+    }
+    
+    if creds != nil {
+        cfg = cfg.WithCredentials(creds)
+    }
+    
+    out, err := sts.New(self.session, cfg).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+    if err != nil {
+        return "", err
+    }
+    
+    return aws.StringValue(out.Account), nil
+}
+
+func (self *EC2Describer) client(accountID string, region string) (*ec2.EC2, error) {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    
+    key := accountID + "/" + region
+    
+    client, ok := self.clients[key]
+    if ok {
+        return client, nil
+    }
+    
+    cfg := aws.NewConfig().WithRegion(region)
+    if self.endpoint != "" {
+        cfg = cfg.WithEndpoint(self.endpoint)
+    }
+    
+    var creds *credentials.Credentials
+    if roleARN, ok := self.roleARNs[accountID]; ok {
+        creds = stscreds.NewCredentials(self.session, roleARN)
+        cfg = cfg.WithCredentials(creds)
+    }
+    
+    callerAccount, err := self.callerAccount(region, creds)
+    if err != nil {
+        return nil, fmt.Errorf("unable to determine AWS account of credentials: %s", err)
+    }
+    
+    if callerAccount != accountID {
+        return nil, fmt.Errorf("no credentials for AWS account %s", accountID)
+    }
+    
+    client = ec2.New(self.session, cfg)
+    self.clients[key] = client
+    
+    return client, nil
+}
+
+func (self *EC2Describer) DescribeInstances(accountID string, region string, input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+    client, err := self.client(accountID, region)
+    if err != nil {
+        return nil, err
+    }
+    
+    return client.DescribeInstances(input)
+}
@@ -0,0 +1,114 @@
+package helpers_test
+
+import (
+    "os"
+    "net/http"
+    "net/http/httptest"
+    
+    "github.com/bluestatedigital/centralbooking/helpers"
+    "github.com/bluestatedigital/centralbooking/interfaces"
+    
+    "github.com/aws/aws-sdk-go/aws"
+    "github.com/aws/aws-sdk-go/service/ec2"
+
+    . "github.com/onsi/ginkgo"
+    . "github.com/onsi/gomega"
+)
+
+const describeInstancesResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+    <requestId>8f7724cf-496f-496e-8fe3-example</requestId>
+    <reservationSet>
+        <item>
+            <reservationId>r-1234567890abcdef0</reservationId>
+            <ownerId>123456789012</ownerId>
+            <instancesSet>
+                <item>
+                    <instanceId>i-04c9c4c4</instanceId>
+                    <instanceState>
+                        <code>16</code>
+                        <name>running</name>
+                    </instanceState>
+                    <privateIpAddress>10.112.16.35</privateIpAddress>
+                    <launchTime>2017-04-20T12:00:00.000Z</launchTime>
+                </item>
+            </instancesSet>
+        </item>
+    </reservationSet>
+</DescribeInstancesResponse>`
+
+const getCallerIdentityResponse = `<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+    <GetCallerIdentityResult>
+        <Arn>arn:aws:iam::123456789012:user/centralbooking</Arn>
+        <UserId>AIDACKCEVSQ6C2EXAMPLE</UserId>
+        <Account>123456789012</Account>
+    </GetCallerIdentityResult>
+    <ResponseMetadata><RequestId>01234567-89ab-cdef-0123-456789abcdef</RequestId></ResponseMetadata>
+</GetCallerIdentityResponse>`
+
+var _ = Describe("EC2Describer", func() {
+    var server *httptest.Server
+    var requests []*http.Request
+    var describer interfaces.EC2Describer
+    
+    BeforeEach(func() {
+        os.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+        os.Setenv("AWS_SECRET_ACCESS_KEY", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+        
+        requests = nil
+        server = httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+            req.ParseForm()
+            requests = append(requests, req)
+            
+            resp.Header().Set("Content-Type", "text/xml")
+            if req.Form.Get("Action") == "GetCallerIdentity" {
+                resp.Write([]byte(getCallerIdentityResponse))
+            } else {
+                resp.Write([]byte(describeInstancesResponse))
+            }
+        }))
+        
+        var err error
+        describer, err = helpers.NewEC2Describer(server.URL, server.URL, nil)
+        Expect(err).To(BeNil())
+    })
+    
+    AfterEach(func() {
+        server.Close()
+        
+        os.Unsetenv("AWS_ACCESS_KEY_ID")
+        os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+    })
+    
+    It("describes instances via the configured endpoint", func() {
+        out, err := describer.DescribeInstances("123456789012", "us-east-1", &ec2.DescribeInstancesInput{
+            InstanceIds: []*string{ aws.String("i-04c9c4c4") },
+        })
+        Expect(err).To(BeNil())
+        
+        // the credentials' account is checked first
+        Expect(requests).To(HaveLen(2))
+        Expect(requests[0].Form.Get("Action")).To(Equal("GetCallerIdentity"))
+        Expect(requests[1].Form.Get("Action")).To(Equal("DescribeInstances"))
+        Expect(requests[1].Form.Get("InstanceId.1")).To(Equal("i-04c9c4c4"))
+        
+        Expect(out.Reservations).To(HaveLen(1))
+        Expect(out.Reservations[0].Instances).To(HaveLen(1))
+        
+        inst := out.Reservations[0].Instances[0]
+        Expect(aws.StringValue(inst.InstanceId)).To(Equal("i-04c9c4c4"))
+        Expect(aws.StringValue(inst.State.Name)).To(Equal("running"))
+        Expect(aws.StringValue(inst.PrivateIpAddress)).To(Equal("10.112.16.35"))
+    })
+    
+    It("refuses to describe instances in other accounts", func() {
+        _, err := describer.DescribeInstances("210987654321", "us-east-1", &ec2.DescribeInstancesInput{
+            InstanceIds: []*string{ aws.String("i-04c9c4c4") },
+        })
+        Expect(err).To(MatchError("no credentials for AWS account 210987654321"))
+        
+        for _, req := range requests {
+            Expect(req.Form.Get("Action")).NotTo(Equal("DescribeInstances"))
+        }
+    })
+})
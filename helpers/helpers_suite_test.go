@@ -0,0 +1,15 @@
+package helpers_test
+
+import (
+    . "github.com/onsi/ginkgo"
+    . "github.com/onsi/gomega"
+
+    "testing"
+    "github.com/Sirupsen/logrus"
+)
+
+func TestHelpers(t *testing.T) {
+    RegisterFailHandler(Fail)
+    logrus.SetLevel(logrus.PanicLevel)
+    RunSpecs(t, "Helpers Suite")
+}
@@ -0,0 +1,79 @@
+package helpers
+
+import (
+    "fmt"
+    "errors"
+    "io/ioutil"
+    "crypto/x509"
+    "encoding/pem"
+    "encoding/json"
+    
+    "github.com/bluestatedigital/centralbooking/interfaces"
+    
+    "github.com/aws/aws-sdk-go/aws/ec2metadata"
+)
+
+// verifies EC2 instance identity documents against AWS' public certificates
+type IdentityVerifier struct {
+    certs []*x509.Certificate
+}
+
+func NewIdentityVerifier(certs []*x509.Certificate) interfaces.IdentityVerifier {
+    return &IdentityVerifier{
+        certs: certs,
+    }
+}
+
+// reads a bundle of PEM-encoded certificates
+func LoadCertificates(path string) ([]*x509.Certificate, error) {
+    pemBytes, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    
+    var certs []*x509.Certificate
+    for {
+        var block *pem.Block
+        block, pemBytes = pem.Decode(pemBytes)
+        if block == nil {
+            break
+        }
+        
+        if block.Type != "CERTIFICATE" {
+            continue
+        }
+        
+        cert, err := x509.ParseCertificate(block.Bytes)
+        if err != nil {
+            return nil, fmt.Errorf("unable to parse certificate in %s: %s", path, err)
+        }
+        
+        certs = append(certs, cert)
+    }
+    
+    if len(certs) == 0 {
+        return nil, fmt.Errorf("no certificates found in %s", path)
+    }
+    
+    return certs, nil
+}
+
+// verifies the PKCS7 signature of the document and returns the parsed document
+func (self *IdentityVerifier) Verify(document, signature []byte) (*ec2metadata.EC2InstanceIdentityDocument, error) {
+    if len(document) == 0 || len(signature) == 0 {
+        return nil, errors.New("identity document and signature required")
+    }
+    
+    err := verifyPKCS7(signature, document, self.certs)
+    if err != nil {
+        return nil, err
+    }
+    
+    var doc ec2metadata.EC2InstanceIdentityDocument
+    err = json.Unmarshal(document, &doc)
+    if err != nil {
+        return nil, fmt.Errorf("unable to parse identity document: %s", err)
+    }
+    
+    return &doc, nil
+}
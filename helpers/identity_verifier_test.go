@@ -0,0 +1,235 @@
+package helpers_test
+
+import (
+    "time"
+    "bytes"
+    "math/big"
+    "crypto"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/sha256"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/asn1"
+    
+    "github.com/bluestatedigital/centralbooking/helpers"
+
+    . "github.com/onsi/ginkgo"
+    . "github.com/onsi/gomega"
+)
+
+var (
+    oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+    oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+    oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+    oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+    oidDigestSHA256  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+    oidEncryptionRSA = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+)
+
+type testContentInfo struct {
+    ContentType asn1.ObjectIdentifier
+    Content     asn1.RawValue `asn1:"optional"`
+}
+
+type testAttribute struct {
+    Type  asn1.ObjectIdentifier
+    Value asn1.RawValue
+}
+
+type testIssuerAndSerial struct {
+    Issuer       asn1.RawValue
+    SerialNumber *big.Int
+}
+
+type testSignerInfo struct {
+    Version                   int
+    IssuerAndSerialNumber     testIssuerAndSerial
+    DigestAlgorithm           pkix.AlgorithmIdentifier
+    AuthenticatedAttributes   []testAttribute `asn1:"optional,tag:0"`
+    DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+    EncryptedDigest           []byte
+}
+
+type testSignedData struct {
+    Version          int
+    DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+    ContentInfo      testContentInfo
+    SignerInfos      []testSignerInfo `asn1:"set"`
+}
+
+func explicitTag(inner []byte) asn1.RawValue {
+    return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: inner}
+}
+
+func asn1Set(inner []byte) asn1.RawValue {
+    return asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: inner}
+}
+
+func mustMarshal(val interface{}) []byte {
+    b, err := asn1.Marshal(val)
+    Expect(err).To(BeNil())
+    return b
+}
+
+// generates a self-signed certificate, like the ones AWS publishes
+func generateCert() (*x509.Certificate, *rsa.PrivateKey) {
+    key, err := rsa.GenerateKey(rand.Reader, 2048)
+    Expect(err).To(BeNil())
+    
+    template := &x509.Certificate{
+        SerialNumber: big.NewInt(42),
+        Subject:      pkix.Name{CommonName: "ec2.amazonaws.com"},
+        NotBefore:    time.Now().Add(-time.Hour),
+        NotAfter:     time.Now().Add(time.Hour),
+    }
+    
+    certBytes, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+    Expect(err).To(BeNil())
+    
+    cert, err := x509.ParseCertificate(certBytes)
+    Expect(err).To(BeNil())
+    
+    return cert, key
+}
+
+// creates a PKCS7 signature of content with signed attributes, as AWS does
+func signPKCS7(content []byte, cert *x509.Certificate, key *rsa.PrivateKey) []byte {
+    digest := sha256.Sum256(content)
+    
+    // sorted, as DER requires
+    attrs := []testAttribute{
+        {oidContentType, asn1Set(mustMarshal(oidData))},
+        {oidMessageDigest, asn1Set(mustMarshal(digest[:]))},
+    }
+    
+    var signedAttrs asn1.RawValue
+    _, err := asn1.Unmarshal(
+        mustMarshal(struct {
+            A []testAttribute `asn1:"set"`
+        }{A: attrs}),
+        &signedAttrs,
+    )
+    Expect(err).To(BeNil())
+    
+    attrsDigest := sha256.Sum256(signedAttrs.Bytes)
+    sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, attrsDigest[:])
+    Expect(err).To(BeNil())
+    
+    sd := testSignedData{
+        Version: 1,
+        DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: oidDigestSHA256}},
+        ContentInfo: testContentInfo{
+            ContentType: oidData,
+            Content:     explicitTag(mustMarshal(content)),
+        },
+        SignerInfos: []testSignerInfo{{
+            Version: 1,
+            IssuerAndSerialNumber: testIssuerAndSerial{
+                Issuer:       asn1.RawValue{FullBytes: cert.RawIssuer},
+                SerialNumber: cert.SerialNumber,
+            },
+            DigestAlgorithm:           pkix.AlgorithmIdentifier{Algorithm: oidDigestSHA256},
+            AuthenticatedAttributes:   attrs,
+            DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidEncryptionRSA},
+            EncryptedDigest:           sig,
+        }},
+    }
+    
+    return mustMarshal(testContentInfo{
+        ContentType: oidSignedData,
+        Content:     explicitTag(mustMarshal(sd)),
+    })
+}
+
+// re-encodes the outer sequence of a DER element with an indefinite length
+func indefiniteLength(der []byte) []byte {
+    var raw asn1.RawValue
+    _, err := asn1.Unmarshal(der, &raw)
+    Expect(err).To(BeNil())
+    
+    ber := append([]byte{der[0], 0x80}, raw.Bytes...)
+    return append(ber, 0x00, 0x00)
+}
+
+var _ = Describe("IdentityVerifier", func() {
+    document := []byte(`{
+  "privateIp" : "10.112.16.35",
+  "availabilityZone" : "us-east-1a",
+  "instanceId" : "i-04c9c4c4",
+  "instanceType" : "t2.micro",
+  "accountId" : "123456789012",
+  "pendingTime" : "2017-04-20T12:00:00Z",
+  "region" : "us-east-1"
+}`)
+    
+    var cert *x509.Certificate
+    var key *rsa.PrivateKey
+    
+    BeforeEach(func() {
+        cert, key = generateCert()
+    })
+    
+    It("verifies a valid signature", func() {
+        verifier := helpers.NewIdentityVerifier([]*x509.Certificate{cert})
+        
+        doc, err := verifier.Verify(document, signPKCS7(document, cert, key))
+        Expect(err).To(BeNil())
+        
+        Expect(doc.InstanceID).To(Equal("i-04c9c4c4"))
+        Expect(doc.AccountID).To(Equal("123456789012"))
+        Expect(doc.Region).To(Equal("us-east-1"))
+        Expect(doc.PrivateIP).To(Equal("10.112.16.35"))
+    })
+    
+    It("verifies a BER-encoded signature", func() {
+        verifier := helpers.NewIdentityVerifier([]*x509.Certificate{cert})
+        
+        _, err := verifier.Verify(document, indefiniteLength(signPKCS7(document, cert, key)))
+        Expect(err).To(BeNil())
+    })
+    
+    It("rejects a tampered document", func() {
+        verifier := helpers.NewIdentityVerifier([]*x509.Certificate{cert})
+        
+        sig := signPKCS7(document, cert, key)
+        tampered := []byte(`{"instanceId":"i-deadbeef","accountId":"123456789012","region":"us-east-1"}`)
+        
+        _, err := verifier.Verify(tampered, sig)
+        Expect(err).To(MatchError("pkcs7: message digest mismatch"))
+    })
+    
+    It("rejects a signature by an untrusted certificate", func() {
+        otherCert, _ := generateCert()
+        verifier := helpers.NewIdentityVerifier([]*x509.Certificate{otherCert})
+        
+        _, err := verifier.Verify(document, signPKCS7(document, cert, key))
+        Expect(err).To(MatchError("pkcs7: signature not valid for any trusted certificate"))
+    })
+    
+    It("rejects deeply nested signatures", func() {
+        verifier := helpers.NewIdentityVerifier([]*x509.Certificate{cert})
+        
+        _, err := verifier.Verify(document, bytes.Repeat([]byte{ 0x30, 0x80 }, 1 << 20))
+        Expect(err).To(MatchError("ber: elements nested too deeply"))
+    })
+    
+    It("rejects a missing signature", func() {
+        verifier := helpers.NewIdentityVerifier([]*x509.Certificate{cert})
+        
+        _, err := verifier.Verify(document, nil)
+        Expect(err).NotTo(BeNil())
+    })
+    
+    It("loads the shipped AWS certificates", func() {
+        certs, err := helpers.LoadCertificates("../dist/aws-identity.pem")
+        Expect(err).To(BeNil())
+        Expect(certs).NotTo(BeEmpty())
+        
+        for _, awsCert := range certs {
+            pub, ok := awsCert.PublicKey.(*rsa.PublicKey)
+            Expect(ok).To(BeTrue())
+            Expect(pub.N.BitLen()).To(Equal(2048))
+        }
+    })
+})
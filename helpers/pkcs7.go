@@ -0,0 +1,215 @@
+package helpers
+
+import (
+    "fmt"
+    "errors"
+    "math/big"
+    "crypto"
+    "crypto/hmac"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/asn1"
+    
+    // register the digests we support
+    _ "crypto/sha1"
+    _ "crypto/sha256"
+)
+
+// just enough PKCS7 (RFC 2315) to verify the signature on an EC2 instance
+// identity document.
+
+var (
+    oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+    oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+    
+    oidDigestSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+    oidDigestSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+    
+    oidEncryptionRSA       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+    oidEncryptionRSASHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 5}
+    oidEncryptionRSASHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+    oidEncryptionDSA       = asn1.ObjectIdentifier{1, 2, 840, 10040, 4, 1}
+    oidEncryptionDSASHA1   = asn1.ObjectIdentifier{1, 2, 840, 10040, 4, 3}
+)
+
+type pkcs7ContentInfo struct {
+    ContentType asn1.ObjectIdentifier
+    Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type pkcs7RawCertificates struct {
+    Raw asn1.RawContent
+}
+
+type pkcs7SignedData struct {
+    Version          int                        `asn1:"default:1"`
+    DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+    ContentInfo      pkcs7ContentInfo
+    Certificates     pkcs7RawCertificates       `asn1:"optional,tag:0"`
+    CRLs             []pkix.CertificateList     `asn1:"optional,tag:1"`
+    SignerInfos      []pkcs7SignerInfo          `asn1:"set"`
+}
+
+type pkcs7IssuerAndSerial struct {
+    Issuer       asn1.RawValue
+    SerialNumber *big.Int
+}
+
+type pkcs7Attribute struct {
+    Type  asn1.ObjectIdentifier
+    Value asn1.RawValue `asn1:"set"`
+}
+
+type pkcs7SignerInfo struct {
+    Version                   int `asn1:"default:1"`
+    IssuerAndSerialNumber     pkcs7IssuerAndSerial
+    DigestAlgorithm           pkix.AlgorithmIdentifier
+    AuthenticatedAttributes   []pkcs7Attribute `asn1:"optional,tag:0"`
+    DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+    EncryptedDigest           []byte
+    UnauthenticatedAttributes []pkcs7Attribute `asn1:"optional,tag:1"`
+}
+
+// verifies that signature is a valid PKCS7 signature of content by one of the
+// given certificates.  the content embedded in the signature, if any, is
+// ignored.
+func verifyPKCS7(signature []byte, content []byte, certs []*x509.Certificate) error {
+    der, err := berToDER(signature)
+    if err != nil {
+        return err
+    }
+    
+    var info pkcs7ContentInfo
+    _, err = asn1.Unmarshal(der, &info)
+    if err != nil {
+        return fmt.Errorf("pkcs7: unable to parse content info: %s", err)
+    }
+    
+    if ! info.ContentType.Equal(oidSignedData) {
+        return errors.New("pkcs7: not signed data")
+    }
+    
+    var sd pkcs7SignedData
+    _, err = asn1.Unmarshal(info.Content.Bytes, &sd)
+    if err != nil {
+        return fmt.Errorf("pkcs7: unable to parse signed data: %s", err)
+    }
+    
+    if len(sd.SignerInfos) == 0 {
+        return errors.New("pkcs7: no signers")
+    }
+    
+    for _, signer := range sd.SignerInfos {
+        err = verifySignerInfo(&signer, content, certs)
+        if err != nil {
+            return err
+        }
+    }
+    
+    return nil
+}
+
+func verifySignerInfo(signer *pkcs7SignerInfo, content []byte, certs []*x509.Certificate) error {
+    var hash crypto.Hash
+    
+    switch {
+        case signer.DigestAlgorithm.Algorithm.Equal(oidDigestSHA1):
+            hash = crypto.SHA1
+        
+        case signer.DigestAlgorithm.Algorithm.Equal(oidDigestSHA256):
+            hash = crypto.SHA256
+        
+        default:
+            return fmt.Errorf("pkcs7: unsupported digest algorithm %s", signer.DigestAlgorithm.Algorithm)
+    }
+    
+    signed := content
+    
+    if len(signer.AuthenticatedAttributes) > 0 {
+        // the signature covers the attributes, which in turn include the
+        // digest of the content
+        h := hash.New()
+        h.Write(content)
+        digest := h.Sum(nil)
+        
+        var messageDigest []byte
+        for _, attr := range signer.AuthenticatedAttributes {
+            if attr.Type.Equal(oidMessageDigest) {
+                _, err := asn1.Unmarshal(attr.Value.Bytes, &messageDigest)
+                if err != nil {
+                    return fmt.Errorf("pkcs7: unable to parse message digest: %s", err)
+                }
+            }
+        }
+        
+        if messageDigest == nil {
+            return errors.New("pkcs7: missing message digest attribute")
+        }
+        
+        if ! hmac.Equal(messageDigest, digest) {
+            return errors.New("pkcs7: message digest mismatch")
+        }
+        
+        var err error
+        signed, err = marshalAttributes(signer.AuthenticatedAttributes)
+        if err != nil {
+            return err
+        }
+    }
+    
+    sigAlg, err := signatureAlgorithm(signer.DigestEncryptionAlgorithm.Algorithm, hash)
+    if err != nil {
+        return err
+    }
+    
+    for _, cert := range certs {
+        if cert.CheckSignature(sigAlg, signed, signer.EncryptedDigest) == nil {
+            return nil
+        }
+    }
+    
+    return errors.New("pkcs7: signature not valid for any trusted certificate")
+}
+
+// returns the DER encoding of the attributes as a SET, which is what is
+// actually signed (not the implicitly-tagged form in the SignerInfo)
+func marshalAttributes(attrs []pkcs7Attribute) ([]byte, error) {
+    encoded, err := asn1.Marshal(struct {
+        A []pkcs7Attribute `asn1:"set"`
+    }{A: attrs})
+    if err != nil {
+        return nil, err
+    }
+    
+    var raw asn1.RawValue
+    _, err = asn1.Unmarshal(encoded, &raw)
+    if err != nil {
+        return nil, err
+    }
+    
+    return raw.Bytes, nil
+}
+
+func signatureAlgorithm(oid asn1.ObjectIdentifier, hash crypto.Hash) (x509.SignatureAlgorithm, error) {
+    switch {
+        case oid.Equal(oidEncryptionRSA), oid.Equal(oidEncryptionRSASHA1), oid.Equal(oidEncryptionRSASHA256):
+            switch hash {
+                case crypto.SHA1:
+                    return x509.SHA1WithRSA, nil
+                
+                case crypto.SHA256:
+                    return x509.SHA256WithRSA, nil
+            }
+        
+        case oid.Equal(oidEncryptionDSA), oid.Equal(oidEncryptionDSASHA1):
+            switch hash {
+                case crypto.SHA1:
+                    return x509.DSAWithSHA1, nil
+                
+                case crypto.SHA256:
+                    return x509.DSAWithSHA256, nil
+            }
+    }
+    
+    return x509.UnknownSignatureAlgorithm, fmt.Errorf("pkcs7: unsupported signature algorithm %s", oid)
+}
@@ -0,0 +1,127 @@
+package helpers
+
+import (
+    "fmt"
+    "net"
+    "strings"
+    "net/http"
+)
+
+// the header trusted proxies record the forwarding chain in
+const (
+    ForwardedHeaderXFF       = "xff"
+    ForwardedHeaderForwarded = "forwarded"
+)
+
+// networks of proxies whose forwarding header can be believed.  a nil
+// *TrustedProxies trusts nothing.
+type TrustedProxies struct {
+    nets   []*net.IPNet
+    header string
+}
+
+// header is ForwardedHeaderXFF or ForwardedHeaderForwarded.  only that
+// header is used; the other is ignored, as the proxies may pass it along from
+// the client untouched.
+func ParseTrustedProxies(cidrs []string, header string) (*TrustedProxies, error) {
+    if header != ForwardedHeaderXFF && header != ForwardedHeaderForwarded {
+        return nil, fmt.Errorf("unsupported forwarding header %q", header)
+    }
+    
+    proxies := &TrustedProxies{
+        nets:   make([]*net.IPNet, 0, len(cidrs)),
+        header: header,
+    }
+    
+    for _, cidr := range cidrs {
+        _, ipNet, err := net.ParseCIDR(cidr)
+        if err != nil {
+            return nil, err
+        }
+        
+        proxies.nets = append(proxies.nets, ipNet)
+    }
+    
+    return proxies, nil
+}
+
+func (self *TrustedProxies) trusts(addr string) bool {
+    if self == nil {
+        return false
+    }
+    
+    ip := net.ParseIP(addr)
+    if ip == nil {
+        return false
+    }
+    
+    for _, ipNet := range self.nets {
+        if ipNet.Contains(ip) {
+            return true
+        }
+    }
+    
+    return false
+}
+
+// returns the address of the client that made the request.  the forwarding
+// chain is walked from the nearest hop outwards, stopping at the first
+// address that isn't a trusted proxy; anything before that could have been
+// made up by the client.
+func (self *TrustedProxies) RemoteAddr(req *http.Request) string {
+    addr := stripPort(req.RemoteAddr)
+    if !self.trusts(addr) {
+        return addr
+    }
+    
+    hops := self.forwardedHops(req)
+    for i := len(hops) - 1; i >= 0; i-- {
+        addr = hops[i]
+        
+        if !self.trusts(addr) {
+            break
+        }
+    }
+    
+    return addr
+}
+
+// returns the forwarding chain, client first, from the configured header
+func (self *TrustedProxies) forwardedHops(req *http.Request) []string {
+    var hops []string
+    
+    if self.header == ForwardedHeaderForwarded {
+        // RFC 7239
+        for _, header := range req.Header["Forwarded"] {
+            for _, element := range strings.Split(header, ",") {
+                for _, pair := range strings.Split(element, ";") {
+                    kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+                    
+                    if len(kv) == 2 && strings.ToLower(kv[0]) == "for" {
+                        hops = append(hops, stripPort(strings.Trim(kv[1], `"`)))
+                    }
+                }
+            }
+        }
+        
+        return hops
+    }
+    
+    for _, header := range req.Header["X-Forwarded-For"] {
+        for _, hop := range strings.Split(header, ",") {
+            hops = append(hops, stripPort(strings.TrimSpace(hop)))
+        }
+    }
+    
+    return hops
+}
+
+// removes the port, and IPv6 brackets, from an address
+func stripPort(addr string) string {
+    host, _, err := net.SplitHostPort(addr)
+    if err == nil {
+        return host
+    }
+    
+    return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
@@ -0,0 +1,132 @@
+package helpers_test
+
+import (
+    "net/http"
+    
+    "github.com/bluestatedigital/centralbooking/helpers"
+
+    . "github.com/onsi/ginkgo"
+    . "github.com/onsi/gomega"
+)
+
+var _ = Describe("TrustedProxies", func() {
+    var proxies *helpers.TrustedProxies
+    
+    request := func(remoteAddr string, headers map[string]string) *http.Request {
+        req, err := http.NewRequest("POST", "http://example.com/v1/register/instance", nil)
+        Expect(err).To(BeNil())
+        
+        req.RemoteAddr = remoteAddr
+        for k, v := range headers {
+            req.Header.Add(k, v)
+        }
+        
+        return req
+    }
+    
+    BeforeEach(func() {
+        var err error
+        proxies, err = helpers.ParseTrustedProxies([]string{ "10.0.0.0/24", "2001:db8::/32" }, helpers.ForwardedHeaderXFF)
+        Expect(err).To(BeNil())
+    })
+    
+    It("rejects invalid networks", func() {
+        _, err := helpers.ParseTrustedProxies([]string{ "10.0.0.1" }, helpers.ForwardedHeaderXFF)
+        Expect(err).NotTo(BeNil())
+    })
+    
+    It("rejects unknown forwarding headers", func() {
+        _, err := helpers.ParseTrustedProxies([]string{ "10.0.0.0/24" }, "x-real-ip")
+        Expect(err).NotTo(BeNil())
+    })
+    
+    It("ignores forwarding headers from untrusted clients", func() {
+        req := request("10.112.16.35:41234", map[string]string{
+            "X-Forwarded-For": "10.112.16.99",
+        })
+        
+        Expect(proxies.RemoteAddr(req)).To(Equal("10.112.16.35"))
+    })
+    
+    It("ignores forwarding headers without trusted proxies", func() {
+        req := request("10.0.0.5:41234", map[string]string{
+            "X-Forwarded-For": "10.112.16.99",
+        })
+        
+        Expect((*helpers.TrustedProxies)(nil).RemoteAddr(req)).To(Equal("10.0.0.5"))
+    })
+    
+    It("stops at the first untrusted X-Forwarded-For hop", func() {
+        req := request("10.0.0.5:41234", map[string]string{
+            "X-Forwarded-For": "6.6.6.6, 10.112.16.35, 10.0.0.6",
+        })
+        
+        Expect(proxies.RemoteAddr(req)).To(Equal("10.112.16.35"))
+    })
+    
+    It("handles repeated X-Forwarded-For headers", func() {
+        req := request("10.0.0.5:41234", nil)
+        req.Header.Add("X-Forwarded-For", "10.112.16.35")
+        req.Header.Add("X-Forwarded-For", "10.0.0.6")
+        
+        Expect(proxies.RemoteAddr(req)).To(Equal("10.112.16.35"))
+    })
+    
+    It("uses the outermost hop if every hop is trusted", func() {
+        req := request("10.0.0.5:41234", map[string]string{
+            "X-Forwarded-For": "10.0.0.7, 10.0.0.6",
+        })
+        
+        Expect(proxies.RemoteAddr(req)).To(Equal("10.0.0.7"))
+    })
+    
+    It("ignores the Forwarded header", func() {
+        req := request("10.0.0.5:41234", map[string]string{
+            "Forwarded":       "for=10.112.16.99",
+            "X-Forwarded-For": "10.112.16.35",
+        })
+        
+        Expect(proxies.RemoteAddr(req)).To(Equal("10.112.16.35"))
+    })
+    
+    Describe("with the Forwarded header", func() {
+        BeforeEach(func() {
+            var err error
+            proxies, err = helpers.ParseTrustedProxies([]string{ "10.0.0.0/24", "2001:db8::/32" }, helpers.ForwardedHeaderForwarded)
+            Expect(err).To(BeNil())
+        })
+        
+        It("walks the for parameters", func() {
+            req := request("10.0.0.5:41234", map[string]string{
+                "Forwarded": `for=6.6.6.6, for="10.112.16.35:8080";proto=https, for="[2001:db8::1]";by=10.0.0.5`,
+            })
+            
+            Expect(proxies.RemoteAddr(req)).To(Equal("10.112.16.35"))
+        })
+        
+        It("ignores X-Forwarded-For", func() {
+            req := request("10.0.0.5:41234", map[string]string{
+                "Forwarded":       "for=10.112.16.35",
+                "X-Forwarded-For": "10.112.16.99",
+            })
+            
+            Expect(proxies.RemoteAddr(req)).To(Equal("10.112.16.35"))
+        })
+        
+        It("ignores X-Forwarded-For even without Forwarded", func() {
+            req := request("10.0.0.5:41234", map[string]string{
+                "X-Forwarded-For": "10.112.16.99",
+            })
+            
+            Expect(proxies.RemoteAddr(req)).To(Equal("10.0.0.5"))
+        })
+        
+        It("treats obfuscated identifiers as untrusted", func() {
+            req := request("10.0.0.5:41234", map[string]string{
+                "Forwarded": "for=10.112.16.35, for=_hidden",
+            })
+            
+            Expect(proxies.RemoteAddr(req)).To(Equal("_hidden"))
+        })
+    })
+})
@@ -1,16 +1,45 @@
 package helpers
 
 import (
+    "fmt"
+    "sync"
+    "time"
+    "errors"
+    "strings"
+    "io/ioutil"
+    "encoding/json"
+    
+    log "github.com/Sirupsen/logrus"
+    
     "github.com/bluestatedigital/centralbooking/interfaces"
     "github.com/hashicorp/vault/api"
 )
 
+// never wait less than this between renewal attempts
+const minRenewInterval = time.Second
+
+// how long a token replaced by logging in again stays valid.  temp tokens
+// created with it are its children and are revoked along with it, so it has
+// to outlive the registrations in flight.
+const replacedTokenGrace = time.Minute
+
 type VaultClient struct {
+    // replaced wholesale when logging in again, so requests in flight keep a
+    // consistent token
     vaultClient *api.Client
+    clientLock  sync.RWMutex
+    
     config      *api.Config
+    
+    // obtains a new token once ours can't be renewed any longer; nil if the
+    // token was given to us
+    login       func() (*api.Secret, error)
+    
+    renewLock   sync.Mutex
+    renewErr    error
 }
 
-func NewVaultClient(vaultEndpoint string, token string) (interfaces.VaultClient, error) {
+func NewVaultClient(vaultEndpoint string, token string) (*VaultClient, error) {
     cfg := api.DefaultConfig()
     cfg.ReadEnvironment()
     cfg.Address = vaultEndpoint
@@ -28,6 +57,113 @@ func NewVaultClient(vaultEndpoint string, token string) (interfaces.VaultClient,
     }, nil
 }
 
+// returns a client that logs in with AppRole, mounted at mountPath.  the
+// role_id and secret_id are read from files, again on every login so they may
+// be rotated underneath us; secretIDFile may be empty if the role doesn't
+// require a secret_id.
+func NewAppRoleVaultClient(vaultEndpoint, mountPath, roleIDFile, secretIDFile string) (*VaultClient, error) {
+    vc, err := NewVaultClient(vaultEndpoint, "")
+    if err != nil {
+        return nil, err
+    }
+    
+    vc.login = func() (*api.Secret, error) {
+        return appRoleLogin(vc.client(), mountPath, roleIDFile, secretIDFile)
+    }
+    
+    _, err = vc.relogin()
+    if err != nil {
+        return nil, err
+    }
+    
+    return vc, nil
+}
+
+func readCredential(path string) (string, error) {
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        return "", err
+    }
+    
+    cred := strings.TrimSpace(string(data))
+    if cred == "" {
+        return "", fmt.Errorf("%s is empty", path)
+    }
+    
+    return cred, nil
+}
+
+func appRoleLogin(vault *api.Client, mountPath, roleIDFile, secretIDFile string) (*api.Secret, error) {
+    roleID, err := readCredential(roleIDFile)
+    if err != nil {
+        return nil, fmt.Errorf("unable to read role_id: %s", err)
+    }
+    
+    data := map[string]interface{}{
+        "role_id": roleID,
+    }
+    
+    if secretIDFile != "" {
+        data["secret_id"], err = readCredential(secretIDFile)
+        if err != nil {
+            return nil, fmt.Errorf("unable to read secret_id: %s", err)
+        }
+    }
+    
+    secret, err := vault.Logical().Write(fmt.Sprintf("auth/%s/login", strings.Trim(mountPath, "/")), data)
+    if err != nil {
+        return nil, fmt.Errorf("unable to log in with AppRole: %s", err)
+    }
+    
+    if secret == nil || secret.Auth == nil {
+        return nil, errors.New("no auth info in AppRole login response")
+    }
+    
+    return secret, nil
+}
+
+// logs in again and switches to the new token.  the replaced token, if any,
+// is revoked after replacedTokenGrace.
+func (self *VaultClient) relogin() (*api.Secret, error) {
+    secret, err := self.login()
+    if err != nil {
+        return nil, err
+    }
+    
+    vault, err := api.NewClient(self.config)
+    if err != nil {
+        return nil, err
+    }
+    
+    vault.SetToken(secret.Auth.ClientToken)
+    
+    self.clientLock.Lock()
+    replaced := self.vaultClient
+    self.vaultClient = vault
+    self.clientLock.Unlock()
+    
+    if replaced.Token() != "" {
+        time.AfterFunc(replacedTokenGrace, func() {
+            log.Debug("revoking replaced Vault token")
+            
+            err := replaced.Auth().Token().RevokeSelf("")
+            if err != nil {
+                log.Warnf("unable to revoke replaced Vault token: %s", err)
+            }
+        })
+    }
+    
+    return secret, nil
+}
+
+// the current underlying client
+func (self *VaultClient) client() *api.Client {
+    self.clientLock.RLock()
+    defer self.clientLock.RUnlock()
+    
+    return self.vaultClient
+}
+
 func (self *VaultClient) GetEndpoint() string {
     return self.config.Address
 }
@@ -40,10 +176,208 @@ func (self *VaultClient) WithToken(token string) interfaces.VaultClient {
     return vc
 }
 
+// returns a client with the same token whose responses are wrapped with the
+// given TTL
+func (self *VaultClient) WithWrapTTL(ttl string) interfaces.VaultClient {
+    vc, _ := NewVaultClient(self.GetEndpoint(), self.client().Token())
+    
+    vc.vaultClient.SetWrappingLookupFunc(func(operation, path string) string {
+        return ttl
+    })
+    
+    return vc
+}
+
 func (self *VaultClient) CreateToken(opts *api.TokenCreateRequest) (*api.Secret, error) {
-    return self.vaultClient.Auth().Token().Create(opts)
+    return self.client().Auth().Token().Create(opts)
+}
+
+// creates a token via auth/token/create/<role>
+func (self *VaultClient) CreateTokenWithRole(opts *api.TokenCreateRequest, role string) (*api.Secret, error) {
+    return self.client().Auth().Token().CreateWithRole(opts, role)
+}
+
+func (self *VaultClient) ReadSecret(path string) (*api.Secret, error) {
+    return self.client().Logical().Read(path)
 }
 
 func (self *VaultClient) WriteSecret(path string, data map[string]interface{}) (*api.Secret, error) {
-    return self.vaultClient.Logical().Write(path, data)
+    return self.client().Logical().Write(path, data)
+}
+
+// revokes the token and all of its children
+func (self *VaultClient) RevokeToken(token string) error {
+    return self.client().Auth().Token().RevokeTree(token)
+}
+
+// revokes the token identified by the accessor, and all of its children
+func (self *VaultClient) RevokeAccessor(accessor string) error {
+    return self.client().Auth().Token().RevokeAccessor(accessor)
+}
+
+func (self *VaultClient) LookupSelf() (*api.Secret, error) {
+    return self.client().Auth().Token().LookupSelf()
+}
+
+func (self *VaultClient) SealStatus() (*api.SealStatusResponse, error) {
+    return self.client().Sys().SealStatus()
+}
+
+// returns the error from the most recent renewal attempt, if it failed
+func (self *VaultClient) RenewalError() error {
+    self.renewLock.Lock()
+    defer self.renewLock.Unlock()
+    
+    return self.renewErr
+}
+
+func (self *VaultClient) setRenewalError(err error) {
+    self.renewLock.Lock()
+    defer self.renewLock.Unlock()
+    
+    self.renewErr = err
+}
+
+// renews the client's own token whenever the given fraction of its TTL has
+// elapsed.  if the client logged in itself, it logs in again once the token
+// can't be renewed, or once renewals are cut short by the token's max TTL.
+// returns nil when stop is closed, or an error once the token has expired and
+// can no longer be renewed or replaced.  tokens without a TTL (like root
+// tokens) are never renewed.
+func (self *VaultClient) RenewToken(fraction float64, stop <-chan struct{}) error {
+    secret, err := self.client().Auth().Token().LookupSelf()
+    if err != nil {
+        self.setRenewalError(err)
+        return fmt.Errorf("unable to look up token: %s", err)
+    }
+    
+    ttl, renewable, err := tokenTTL(secret)
+    if err != nil {
+        return err
+    }
+    
+    // each renewal asks for the TTL the token was created with
+    increment := ttl
+    if creationTTL, err := jsonSeconds(secret.Data["creation_ttl"]); err == nil && creationTTL > 0 {
+        increment = time.Duration(creationTTL) * time.Second
+    }
+    
+    if ttl == 0 {
+        log.Info("Vault token does not expire; not renewing")
+        <-stop
+        return nil
+    }
+    
+    expires := time.Now().Add(ttl)
+    
+    for {
+        wait := time.Duration(float64(ttl) * fraction)
+        if ! renewable && self.login == nil {
+            // nothing to do but wait for it to expire
+            log.Warnf("Vault token is not renewable and expires in %s", ttl)
+            wait = ttl
+        } else if wait < minRenewInterval {
+            wait = minRenewInterval
+        }
+        
+        select {
+            case <-stop:
+                return nil
+            
+            case <-time.After(wait):
+        }
+        
+        if renewable {
+            log.Debug("renewing Vault token")
+            secret, err = self.client().Auth().Token().RenewSelf(int(increment.Seconds()))
+            if err == nil && secret.Auth == nil {
+                err = errors.New("no auth info in renewal response")
+            }
+            
+            if err != nil {
+                log.Errorf("unable to renew Vault token: %s", err)
+            }
+        } else {
+            err = errors.New("token is not renewable")
+        }
+        
+        if err != nil && self.login != nil {
+            log.Info("logging in to Vault again")
+            secret, err = self.relogin()
+            if err != nil {
+                log.Errorf("unable to log in to Vault: %s", err)
+            } else {
+                increment = time.Duration(secret.Auth.LeaseDuration) * time.Second
+            }
+        } else if err == nil && self.login != nil {
+            // the token is running into its max TTL; replace it while it's
+            // still valid rather than renewing it to the bitter end
+            leaseDuration := time.Duration(secret.Auth.LeaseDuration) * time.Second
+            if leaseDuration < increment || leaseDuration < wait {
+                log.Infof("Vault token renewed for only %s; logging in again", leaseDuration)
+                
+                loginSecret, loginErr := self.relogin()
+                if loginErr != nil {
+                    // carry on with the renewed token while it lasts
+                    log.Errorf("unable to log in to Vault: %s", loginErr)
+                } else {
+                    secret = loginSecret
+                    increment = time.Duration(secret.Auth.LeaseDuration) * time.Second
+                }
+            }
+        }
+        
+        if err != nil {
+            self.setRenewalError(err)
+            
+            ttl = expires.Sub(time.Now())
+            if ttl <= 0 {
+                return fmt.Errorf("token expired: %s", err)
+            }
+            
+            continue
+        }
+        
+        self.setRenewalError(nil)
+        
+        ttl = time.Duration(secret.Auth.LeaseDuration) * time.Second
+        renewable = secret.Auth.Renewable
+        expires = time.Now().Add(ttl)
+        
+        log.Debugf("Vault token expires in %s", ttl)
+    }
+}
+
+// extracts the TTL and renewability from a token lookup
+func tokenTTL(secret *api.Secret) (time.Duration, bool, error) {
+    if secret == nil || secret.Data == nil {
+        return 0, false, errors.New("no data in token lookup")
+    }
+    
+    ttl, err := jsonSeconds(secret.Data["ttl"])
+    if err != nil {
+        return 0, false, fmt.Errorf("invalid token ttl: %s", err)
+    }
+    
+    renewable, _ := secret.Data["renewable"].(bool)
+    
+    return time.Duration(ttl) * time.Second, renewable, nil
+}
+
+// a number of seconds from a Vault response
+func jsonSeconds(v interface{}) (int64, error) {
+    switch n := v.(type) {
+        case json.Number:
+            seconds, err := n.Int64()
+            if err != nil {
+                return 0, fmt.Errorf("invalid number %q", n)
+            }
+            
+            return seconds, nil
+        
+        case float64:
+            return int64(n), nil
+    }
+    
+    return 0, fmt.Errorf("invalid number %v", v)
 }
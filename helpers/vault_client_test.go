@@ -0,0 +1,201 @@
+package helpers_test
+
+import (
+    "os"
+    "time"
+    "sync"
+    "io/ioutil"
+    "encoding/json"
+    "path/filepath"
+    "net/http"
+    "net/http/httptest"
+    
+    "github.com/bluestatedigital/centralbooking/helpers"
+
+    . "github.com/onsi/ginkgo"
+    . "github.com/onsi/gomega"
+)
+
+var _ = Describe("VaultClient", func() {
+    var server *httptest.Server
+    var vaultClient *helpers.VaultClient
+    
+    var lock sync.Mutex
+    var renewals int
+    var lookupResponse string
+    var renewStatus int
+    var logins []map[string]string
+    
+    BeforeEach(func() {
+        renewals = 0
+        renewStatus = http.StatusOK
+        logins = nil
+        
+        server = httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+            lock.Lock()
+            defer lock.Unlock()
+            
+            resp.Header().Set("Content-Type", "application/json")
+            
+            switch req.URL.Path {
+                case "/v1/auth/token/lookup-self":
+                    resp.Write([]byte(lookupResponse))
+                
+                case "/v1/auth/token/renew-self":
+                    renewals += 1
+                    
+                    resp.WriteHeader(renewStatus)
+                    if renewStatus == http.StatusOK {
+                        resp.Write([]byte(`{"auth": {"client_token": "our-token", "lease_duration": 2, "renewable": true}}`))
+                    } else {
+                        resp.Write([]byte(`{"errors": ["permission denied"]}`))
+                    }
+                
+                case "/v1/auth/approle/login":
+                    var body map[string]string
+                    json.NewDecoder(req.Body).Decode(&body)
+                    logins = append(logins, body)
+                    
+                    resp.Write([]byte(`{"auth": {"client_token": "approle-token", "lease_duration": 2, "renewable": false}}`))
+                
+                default:
+                    resp.WriteHeader(http.StatusNotFound)
+            }
+        }))
+        
+        var err error
+        vaultClient, err = helpers.NewVaultClient(server.URL, "our-token")
+        Expect(err).To(BeNil())
+    })
+    
+    AfterEach(func() {
+        server.Close()
+    })
+    
+    Describe("token renewal", func() {
+        It("renews the token periodically", func() {
+            lookupResponse = `{"data": {"ttl": 2, "renewable": true}}`
+            
+            stop := make(chan struct{})
+            done := make(chan error)
+            go func() {
+                done <- vaultClient.RenewToken(0.5, stop)
+            }()
+            
+            Eventually(func() int {
+                lock.Lock()
+                defer lock.Unlock()
+                
+                return renewals
+            }, 5 * time.Second).Should(BeNumerically(">=", 2))
+            
+            close(stop)
+            Eventually(done).Should(Receive(BeNil()))
+            Expect(vaultClient.RenewalError()).To(BeNil())
+        })
+        
+        It("gives up once the token expires", func() {
+            lookupResponse = `{"data": {"ttl": 1, "renewable": true}}`
+            renewStatus = http.StatusForbidden
+            
+            err := vaultClient.RenewToken(0.5, nil)
+            Expect(err).NotTo(BeNil())
+            Expect(vaultClient.RenewalError()).NotTo(BeNil())
+        })
+        
+        It("does not renew tokens without a TTL", func() {
+            lookupResponse = `{"data": {"ttl": 0, "renewable": false}}`
+            
+            stop := make(chan struct{})
+            close(stop)
+            
+            Expect(vaultClient.RenewToken(0.5, stop)).To(BeNil())
+            Expect(renewals).To(Equal(0))
+        })
+    })
+    
+    Describe("AppRole login", func() {
+        var credDir string
+        var roleIDFile, secretIDFile string
+        
+        BeforeEach(func() {
+            var err error
+            credDir, err = ioutil.TempDir("", "centralbooking")
+            Expect(err).To(BeNil())
+            
+            roleIDFile = filepath.Join(credDir, "role_id")
+            secretIDFile = filepath.Join(credDir, "secret_id")
+            
+            Expect(ioutil.WriteFile(roleIDFile, []byte("our-role-id\n"), 0600)).To(BeNil())
+            Expect(ioutil.WriteFile(secretIDFile, []byte("our-secret-id\n"), 0600)).To(BeNil())
+        })
+        
+        AfterEach(func() {
+            os.RemoveAll(credDir)
+        })
+        
+        It("logs in with the role_id and secret_id", func() {
+            _, err := helpers.NewAppRoleVaultClient(server.URL, "approle", roleIDFile, secretIDFile)
+            Expect(err).To(BeNil())
+            
+            Expect(logins).To(Equal([]map[string]string{
+                { "role_id": "our-role-id", "secret_id": "our-secret-id" },
+            }))
+        })
+        
+        It("fails if the secret_id can't be read", func() {
+            _, err := helpers.NewAppRoleVaultClient(server.URL, "approle", roleIDFile, filepath.Join(credDir, "missing"))
+            Expect(err).NotTo(BeNil())
+            Expect(logins).To(BeEmpty())
+        })
+        
+        It("logs in again once the token can't be renewed", func() {
+            vaultClient, err := helpers.NewAppRoleVaultClient(server.URL, "approle", roleIDFile, secretIDFile)
+            Expect(err).To(BeNil())
+            
+            lookupResponse = `{"data": {"ttl": 2, "renewable": false}}`
+            
+            stop := make(chan struct{})
+            done := make(chan error)
+            go func() {
+                done <- vaultClient.RenewToken(0.5, stop)
+            }()
+            
+            Eventually(func() int {
+                lock.Lock()
+                defer lock.Unlock()
+                
+                return len(logins)
+            }, 5 * time.Second).Should(BeNumerically(">=", 2))
+            
+            close(stop)
+            Eventually(done).Should(Receive(BeNil()))
+            Expect(vaultClient.RenewalError()).To(BeNil())
+        })
+        
+        It("logs in again once renewals are cut short", func() {
+            vaultClient, err := helpers.NewAppRoleVaultClient(server.URL, "approle", roleIDFile, secretIDFile)
+            Expect(err).To(BeNil())
+            
+            // renewals only get 2 of the 4 seconds asked for
+            lookupResponse = `{"data": {"ttl": 2, "creation_ttl": 4, "renewable": true}}`
+            
+            stop := make(chan struct{})
+            done := make(chan error)
+            go func() {
+                done <- vaultClient.RenewToken(0.5, stop)
+            }()
+            
+            Eventually(func() int {
+                lock.Lock()
+                defer lock.Unlock()
+                
+                return len(logins)
+            }, 5 * time.Second).Should(BeNumerically(">=", 2))
+            
+            close(stop)
+            Eventually(done).Should(Receive(BeNil()))
+            Expect(renewals).To(Equal(1))
+        })
+    })
+})
@@ -0,0 +1,133 @@
+package instance
+
+import (
+    log "github.com/Sirupsen/logrus"
+    "net"
+    "errors"
+    "strings"
+    "encoding/json"
+    
+    "github.com/bluestatedigital/centralbooking/config"
+    "github.com/bluestatedigital/centralbooking/interfaces"
+)
+
+// returns the path of the AppRole role the instance is issued a SecretID for.
+// policies come from the AppRole, so none may be requested.
+func (self *Registrar) resolveAppRole(req *RegisterRequest, roleCfg *config.RoleConfig) (string, error) {
+    if roleCfg == nil {
+        return "", &ValidationError{"no approle for role"}
+    }
+    
+    if len(req.Policies) > 0 {
+        return "", &ValidationError{"policies cannot be requested in approle mode"}
+    }
+    
+    return self.config.Vault.AppRoleRolePath(roleCfg.AppRole), nil
+}
+
+// returns a CIDR matching only the given address
+func hostCIDR(addr string) string {
+    ip := net.ParseIP(addr)
+    if ip != nil && ip.To4() == nil {
+        return addr + "/128"
+    }
+    
+    return addr + "/32"
+}
+
+// generates a SecretID for the AppRole role at rolePath, usable only from the
+// instance's address, and writes it and the role_id, and any extra entries, to
+// the cubbyhole of a limited-use temp token.  returns the temp token and the
+// SecretID's accessor.
+func (self *Registrar) createAppRoleSecretID(req *RegisterRequest, rolePath string, extra map[string]map[string]interface{}, metadata map[string]string, tokenCfg *config.TokenConfig, logEntry *log.Entry) (string, string, error) {
+    logEntry = logEntry.WithField("approle", rolePath)
+    
+    logEntry.Debug("reading role_id")
+    roleIDSecret, err := self.vaultClient.ReadSecret(rolePath + "/role-id")
+    if err == nil && roleIDSecret == nil {
+        err = errors.New("no such role")
+    }
+    
+    if err != nil {
+        logEntry.Errorf("error reading role_id: %+v", err)
+        return "", "", errors.New("unable to read role_id")
+    }
+    
+    roleID, _ := roleIDSecret.Data["role_id"].(string)
+    
+    // Vault wants the metadata as a JSON string
+    metadataJSON, err := json.Marshal(metadata)
+    if err != nil {
+        return "", "", err
+    }
+    
+    logEntry.Debug("creating secret_id")
+    secretIDSecret, err := self.vaultClient.WriteSecret(rolePath + "/secret-id", map[string]interface{}{
+        "metadata":  string(metadataJSON),
+        "cidr_list": hostCIDR(req.RemoteAddr),
+    })
+    if err == nil && secretIDSecret == nil {
+        err = errors.New("empty response")
+    }
+    
+    if err != nil {
+        logEntry.Errorf("error creating secret_id: %+v", err)
+        return "", "", errors.New("unable to create secret_id")
+    }
+    
+    secretID, _ := secretIDSecret.Data["secret_id"].(string)
+    secretIDAccessor, _ := secretIDSecret.Data["secret_id_accessor"].(string)
+    
+    logEntry = logEntry.WithField("secret_id_accessor", secretIDAccessor)
+    
+    tempToken, err := self.createTempToken(req, metadata, tokenCfg, len(extra), logEntry)
+    if err != nil {
+        destroySecretID(self.vaultClient, rolePath, secretIDAccessor, logEntry)
+        return "", "", err
+    }
+    
+    logEntry.Debug("writing to cubbyhole/approle")
+    _, err = self.vaultClient.
+        WithToken(tempToken).
+        WriteSecret("cubbyhole/approle", map[string]interface{}{
+            "role_id":   roleID,
+            "secret_id": secretID,
+        })
+    
+    if err != nil {
+        logEntry.Errorf("error writing to cubbyhole/approle: %+v", err)
+        self.revokeTokens(logEntry, tempToken)
+        destroySecretID(self.vaultClient, rolePath, secretIDAccessor, logEntry)
+        return "", "", errors.New("unable to store secret_id")
+    }
+    
+    err = self.writeCubbyhole(tempToken, extra, logEntry)
+    if err != nil {
+        self.revokeTokens(logEntry, tempToken)
+        destroySecretID(self.vaultClient, rolePath, secretIDAccessor, logEntry)
+        return "", "", err
+    }
+    
+    return tempToken, secretIDAccessor, nil
+}
+
+// destroys the SecretID with the given accessor.  SecretIDs that are already
+// gone are fine.
+func destroySecretID(vaultClient interfaces.VaultClient, rolePath string, accessor string, logEntry *log.Entry) error {
+    if accessor == "" {
+        logEntry.Warn("no secret_id accessor; unable to destroy secret_id")
+        return nil
+    }
+    
+    _, err := vaultClient.WriteSecret(rolePath + "/secret-id-accessor/destroy", map[string]interface{}{
+        "secret_id_accessor": accessor,
+    })
+    
+    // expired SecretIDs are eventually tidied away
+    if err != nil && !strings.Contains(err.Error(), "failed to find accessor entry") {
+        logEntry.Errorf("error destroying secret_id: %+v", err)
+        return errors.New("unable to destroy secret_id")
+    }
+    
+    return nil
+}
@@ -0,0 +1,55 @@
+package instance
+
+import (
+    log "github.com/Sirupsen/logrus"
+    "fmt"
+    "errors"
+    
+    "github.com/bluestatedigital/centralbooking/config"
+    "github.com/bluestatedigital/centralbooking/interfaces"
+    
+    consulapi "github.com/hashicorp/consul/api"
+)
+
+// creates a client ACL token for the instance with the role's rules.  returns
+// the token's ID.
+func (self *Registrar) createConsulACLToken(req *RegisterRequest, aclCfg *config.ConsulACLConfig, logEntry *log.Entry) (string, error) {
+    rules, err := aclCfg.Render(req.vars())
+    if err != nil {
+        logEntry.Errorf("error rendering consul acl rules: %+v", err)
+        return "", errors.New("unable to create consul acl token")
+    }
+    
+    logEntry.Debug("creating consul acl token")
+    id, _, err := self.consulACL.Create(&consulapi.ACLEntry{
+        Name: fmt.Sprintf(
+            "instance %s/%s/%s/%s/%s",
+            req.Env,
+            req.Provider,
+            req.Account,
+            req.Region,
+            req.InstanceID,
+        ),
+        Type:  consulapi.ACLClientType,
+        Rules: rules,
+    }, nil)
+    
+    if err != nil {
+        logEntry.Errorf("error creating consul acl token: %+v", err)
+        return "", errors.New("unable to create consul acl token")
+    }
+    
+    return id, nil
+}
+
+// destroys the Consul ACL token with the given ID.  destroying a token that's
+// already gone succeeds.
+func destroyConsulACLToken(consulACL interfaces.ConsulACL, id string, logEntry *log.Entry) error {
+    _, err := consulACL.Destroy(id, nil)
+    if err != nil {
+        logEntry.Errorf("error destroying consul acl token: %+v", err)
+        return errors.New("unable to destroy consul acl token")
+    }
+    
+    return nil
+}
@@ -0,0 +1,107 @@
+package instance
+
+import (
+    log "github.com/Sirupsen/logrus"
+    "fmt"
+    "sort"
+    "errors"
+    
+    "github.com/bluestatedigital/centralbooking/config"
+    
+    vaultapi "github.com/hashicorp/vault/api"
+)
+
+// creates the perm token and writes it, and any extra entries, to the
+// cubbyhole of a limited-use temp token.  returns the temp token and the perm
+// token's accessor.
+func (self *Registrar) createCubbyholeToken(req *RegisterRequest, permRequest *vaultapi.TokenCreateRequest, tokenRole string, extra map[string]map[string]interface{}, metadata map[string]string, tokenCfg *config.TokenConfig, logEntry *log.Entry) (string, string, error) {
+    logEntry.Debug("creating perm token")
+    permSecret, err := createPermToken(self.vaultClient, permRequest, tokenRole)
+    if err != nil {
+        logEntry.Errorf("error creating perm token: %+v", err)
+        return "", "", errors.New("unable to create token")
+    }
+    
+    tempToken, err := self.createTempToken(req, metadata, tokenCfg, len(extra), logEntry)
+    if err != nil {
+        self.revokeTokens(logEntry, permSecret.Auth.ClientToken)
+        return "", "", err
+    }
+    
+    logEntry.Debug("writing to cubbyhole/perm")
+    _, err = self.vaultClient.
+        WithToken(tempToken).
+        WriteSecret("cubbyhole/perm", map[string]interface{}{
+            "payload": permSecret,
+        })
+    
+    if err != nil {
+        logEntry.Errorf("error writing to cubbyhole/perm: %+v", err)
+        self.revokeTokens(logEntry, tempToken, permSecret.Auth.ClientToken)
+        return "", "", errors.New("unable to store perm token")
+    }
+    
+    err = self.writeCubbyhole(tempToken, extra, logEntry)
+    if err != nil {
+        self.revokeTokens(logEntry, tempToken, permSecret.Auth.ClientToken)
+        return "", "", err
+    }
+    
+    return tempToken, permSecret.Auth.Accessor, nil
+}
+
+// creates the temp token whose cubbyhole the instance reads its credentials
+// from.  each extra entry in the cubbyhole costs two more uses: one to write
+// it and one to read it.
+func (self *Registrar) createTempToken(req *RegisterRequest, metadata map[string]string, tokenCfg *config.TokenConfig, extraEntries int, logEntry *log.Entry) (string, error) {
+    logEntry.Debug("creating temp token")
+    tempSecret, err := self.vaultClient.CreateToken(&vaultapi.TokenCreateRequest{
+        DisplayName: fmt.Sprintf(
+            "temp instance %s/%s/%s/%s/%s",
+            req.Env,
+            req.Provider,
+            req.Account,
+            req.Region,
+            req.InstanceID,
+        ),
+        Metadata: metadata,
+        
+        // only its cubbyhole is needed, which any token can use; without
+        // policies it would inherit all of centralbooking's
+        Policies: []string{ "default" },
+        
+        Lease: tokenCfg.TempTTL.String(),
+        NumUses: tokenCfg.TempNumUses + 2 * extraEntries,
+    })
+    
+    if err != nil {
+        logEntry.Errorf("error creating temp token: %+v", err)
+        return "", errors.New("unable to create token")
+    }
+    
+    return tempSecret.Auth.ClientToken, nil
+}
+
+// writes entries to the temp token's cubbyhole, at cubbyhole/<name>
+func (self *Registrar) writeCubbyhole(tempToken string, entries map[string]map[string]interface{}, logEntry *log.Entry) error {
+    names := make([]string, 0, len(entries))
+    for name := range entries {
+        names = append(names, name)
+    }
+    
+    sort.Strings(names)
+    
+    tempClient := self.vaultClient.WithToken(tempToken)
+    for _, name := range names {
+        path := "cubbyhole/" + name
+        
+        logEntry.Debugf("writing to %s", path)
+        _, err := tempClient.WriteSecret(path, entries[name])
+        if err != nil {
+            logEntry.Errorf("error writing to %s: %+v", path, err)
+            return fmt.Errorf("unable to store %s", path)
+        }
+    }
+    
+    return nil
+}
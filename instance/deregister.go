@@ -0,0 +1,111 @@
+package instance
+
+import (
+    log "github.com/Sirupsen/logrus"
+    "errors"
+    "strings"
+    
+    "github.com/bluestatedigital/centralbooking/interfaces"
+)
+
+// revokes the instance's credentials and removes its record
+func (self *Registrar) Deregister(req *InstanceRequest) error {
+    logEntry := log.WithFields(log.Fields{
+        "environment": req.Env,
+        "provider":    req.Provider,
+        "account":     req.Account,
+        "region":      req.Region,
+        "instance_id": req.InstanceID,
+    })
+    
+    record, err := self.findRecord(req, logEntry)
+    if err != nil {
+        return err
+    }
+    
+    logEntry.Info("deregistering instance")
+    
+    return revokeRecord(self.vaultClient, self.consulACL, self.inventory, record, logEntry)
+}
+
+// returns the record of the instance named in an admin request
+func (self *Registrar) findRecord(req *InstanceRequest, logEntry *log.Entry) (*Record, error) {
+    accountID := self.config.AWS.AccountID(req.Account)
+    if !validName(req.Provider) || !validName(accountID) || !validName(req.InstanceID) {
+        return nil, &ValidationError{"invalid instance"}
+    }
+    
+    record, err := self.inventory.Get(req.Provider, accountID, req.InstanceID)
+    if err != nil {
+        logEntry.Errorf("error retrieving instance record: %+v", err)
+        return nil, errors.New("unable to retrieve instance record")
+    }
+    
+    // the path names the environment and region too; an instance registered
+    // elsewhere isn't the one being asked about
+    if record == nil || record.Environment != req.Env || record.Region != req.Region {
+        return nil, &NotFoundError{"instance not registered"}
+    }
+    
+    return record, nil
+}
+
+// revokes the recorded credentials and deletes the record.  the record is kept
+// if they can't be revoked, so that we can try again.
+func revokeRecord(vaultClient interfaces.VaultClient, consulACL interfaces.ConsulACL, inv *inventory, record *Record, logEntry *log.Entry) error {
+    logEntry = logEntry.WithFields(record.logFields())
+    
+    err := revokeCredentials(vaultClient, consulACL, record, logEntry)
+    if err != nil {
+        return err
+    }
+    
+    err = inv.Delete(record)
+    if err != nil {
+        logEntry.Errorf("error deleting instance record: %+v", err)
+        return errors.New("unable to delete instance record")
+    }
+    
+    return nil
+}
+
+// revokes whatever the instance was issued: the perm token or the AppRole
+// SecretID, and its Consul ACL token
+func revokeCredentials(vaultClient interfaces.VaultClient, consulACL interfaces.ConsulACL, record *Record, logEntry *log.Entry) error {
+    var err error
+    if record.SecretIDAccessor != "" {
+        err = destroySecretID(vaultClient, record.AppRole, record.SecretIDAccessor, logEntry)
+    } else {
+        err = revokeAccessor(vaultClient, record.Accessor, logEntry)
+    }
+    
+    if err != nil {
+        return err
+    }
+    
+    if record.ConsulACLToken != "" {
+        return destroyConsulACLToken(consulACL, record.ConsulACLToken, logEntry)
+    }
+    
+    return nil
+}
+
+// revokes the token with the given accessor, and its children.  tokens that
+// are already gone are fine.
+func revokeAccessor(vaultClient interfaces.VaultClient, accessor string, logEntry *log.Entry) error {
+    if accessor == "" {
+        logEntry.Warn("no accessor recorded; unable to revoke perm token")
+        return nil
+    }
+    
+    err := vaultClient.RevokeAccessor(accessor)
+    
+    // an accessor is invalid once its token has expired or been revoked,
+    // which is what we want anyway
+    if err != nil && !strings.Contains(err.Error(), "invalid accessor") {
+        logEntry.Errorf("error revoking perm token: %+v", err)
+        return errors.New("unable to revoke token")
+    }
+    
+    return nil
+}
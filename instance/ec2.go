@@ -0,0 +1,67 @@
+package instance
+
+import (
+    "time"
+    "errors"
+    
+    log "github.com/Sirupsen/logrus"
+    
+    "github.com/aws/aws-sdk-go/aws"
+    "github.com/aws/aws-sdk-go/aws/awserr"
+    "github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// cross-checks the registering instance against the EC2 API: it must be
+// running, recently launched, and registering from its own private IP.  it is
+// described in the account it claims to belong to.
+func (self *Registrar) verifyInstance(req *RegisterRequest, logEntry *log.Entry) error {
+    accountID := self.config.AWS.AccountID(req.Account)
+    
+    out, err := self.ec2Describer.DescribeInstances(accountID, req.Region, &ec2.DescribeInstancesInput{
+        InstanceIds: []*string{ aws.String(req.InstanceID) },
+    })
+    
+    if err != nil {
+        if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "InvalidInstanceID.NotFound" {
+            return &ValidationError{"instance not found"}
+        }
+        
+        logEntry.Errorf("unable to describe instance: %+v", err)
+        return errors.New("unable to describe instance")
+    }
+    
+    var inst *ec2.Instance
+    for _, reservation := range out.Reservations {
+        if reservation.OwnerId != nil && aws.StringValue(reservation.OwnerId) != accountID {
+            continue
+        }
+        
+        for _, i := range reservation.Instances {
+            if aws.StringValue(i.InstanceId) == req.InstanceID {
+                inst = i
+            }
+        }
+    }
+    
+    if inst == nil {
+        return &ValidationError{"instance not found"}
+    }
+    
+    if inst.State == nil || aws.StringValue(inst.State.Name) != ec2.InstanceStateNameRunning {
+        logEntry.Warn("instance is not running")
+        return &ValidationError{"instance is not running"}
+    }
+    
+    launchAge := time.Since(aws.TimeValue(inst.LaunchTime))
+    if launchAge > self.config.AWS.MaxLaunchAge.Duration {
+        logEntry.Warnf("instance launched %s ago", launchAge)
+        return &ValidationError{"instance launched too long ago"}
+    }
+    
+    if aws.StringValue(inst.PrivateIpAddress) != req.RemoteAddr {
+        logEntry.Warnf("instance private IP is %s", aws.StringValue(inst.PrivateIpAddress))
+        return &ValidationError{"remote address does not match instance"}
+    }
+    
+    return nil
+}
@@ -7,3 +7,21 @@ type ValidationError struct {
 func (self *ValidationError) Error() string {
     return self.msg
 }
+
+// the instance isn't registered
+type NotFoundError struct {
+    msg string
+}
+
+func (self *NotFoundError) Error() string {
+    return self.msg
+}
+
+// the instance is already registered
+type ConflictError struct {
+    msg string
+}
+
+func (self *ConflictError) Error() string {
+    return self.msg
+}
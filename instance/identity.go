@@ -0,0 +1,36 @@
+package instance
+
+import (
+    log "github.com/Sirupsen/logrus"
+)
+
+// verifies the instance's signed identity document and ensures it matches the
+// identity claimed in the request
+func (self *Registrar) verifyIdentity(req *RegisterRequest, logEntry *log.Entry) error {
+    if req.Provider != "aws" {
+        return &ValidationError{"unsupported provider"}
+    }
+    
+    doc, err := self.identityVerifier.Verify(req.IdentityDocument, req.IdentitySignature)
+    if err != nil {
+        logEntry.Warnf("unable to verify identity document: %+v", err)
+        return &ValidationError{"invalid identity document"}
+    }
+    
+    if doc.InstanceID != req.InstanceID {
+        logEntry.Warnf("identity document is for instance %s", doc.InstanceID)
+        return &ValidationError{"identity document does not match instance_id"}
+    }
+    
+    if doc.AccountID != self.config.AWS.AccountID(req.Account) {
+        logEntry.Warnf("identity document is for account %s", doc.AccountID)
+        return &ValidationError{"identity document does not match account"}
+    }
+    
+    if doc.Region != req.Region {
+        logEntry.Warnf("identity document is for region %s", doc.Region)
+        return &ValidationError{"identity document does not match region"}
+    }
+    
+    return nil
+}
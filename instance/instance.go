@@ -4,20 +4,32 @@ package instance
 import (
     log "github.com/Sirupsen/logrus"
     "fmt"
+    "time"
     "errors"
     
+    "github.com/bluestatedigital/centralbooking/config"
     "github.com/bluestatedigital/centralbooking/interfaces"
     
     vaultapi "github.com/hashicorp/vault/api"
 )
 
 type Registrar struct {
-    vaultClient interfaces.VaultClient
+    vaultClient      interfaces.VaultClient
+    identityVerifier interfaces.IdentityVerifier
+    ec2Describer     interfaces.EC2Describer
+    consulACL        interfaces.ConsulACL
+    inventory        *inventory
+    config           *config.Config
 }
 
-func NewRegistrar(vaultClient interfaces.VaultClient) *Registrar {
+func NewRegistrar(vaultClient interfaces.VaultClient, identityVerifier interfaces.IdentityVerifier, ec2Describer interfaces.EC2Describer, consulKV interfaces.ConsulKV, consulACL interfaces.ConsulACL, cfg *config.Config) *Registrar {
     return &Registrar{
-        vaultClient: vaultClient,
+        vaultClient:      vaultClient,
+        identityVerifier: identityVerifier,
+        ec2Describer:     ec2Describer,
+        consulACL:        consulACL,
+        inventory:        newInventory(consulKV, cfg.Consul.InstancePrefix),
+        config:           cfg,
     }
 }
 
@@ -44,22 +56,180 @@ func (self *Registrar) Register(req *RegisterRequest) (*RegisterResponse, error)
         "role":        req.Role,
     })
     
-    // at least one policy must be provided
-    if len(req.Policies) == 0 {
-        return nil, &ValidationError{"no policies specified"}
+    // these are matched against globs and end up in templates, paths and
+    // comma-separated lists
+    if !validName(req.Env) || !validName(req.Role) || !validName(req.Account) {
+        logEntry.Warn("invalid environment, role or account")
+        return nil, &ValidationError{"invalid environment, role or account"}
     }
     
-    // disallow creating tokens with the root policy
-    for _, p := range req.Policies {
-        if p == "root" {
-            return nil, &ValidationError{"illegal policy"}
-        }
+    if _, ok := self.config.Environment(req.Env); !ok {
+        logEntry.Warn("unknown environment")
+        return nil, &ValidationError{"unknown environment"}
+    }
+    
+    // networks and roles are configured by account name
+    if !self.config.AWS.KnownAccount(req.Account) {
+        logEntry.Warn("unknown account")
+        return nil, &ValidationError{"unknown account"}
+    }
+    
+    roleCfg := self.config.FindRole(req.Env, req.Provider, req.Account, req.Role)
+    
+    var policies []string
+    var appRole string
+    if self.config.Vault.RegistrationMode == config.RegistrationModeAppRole {
+        appRole, err = self.resolveAppRole(req, roleCfg)
+    } else {
+        policies, err = self.resolvePolicies(req, roleCfg)
+    }
+    
+    if err != nil {
+        return nil, err
+    }
+    
+    if req.SSHHostPublicKey != "" && !validSSHPublicKey(req.SSHHostPublicKey) {
+        return nil, &ValidationError{"invalid ssh host public key"}
+    }
+    
+    err = self.verifyRemoteAddr(req, logEntry)
+    if err != nil {
+        return nil, err
+    }
+    
+    err = self.verifyIdentity(req, logEntry)
+    if err != nil {
+        return nil, err
+    }
+    
+    err = self.verifyInstance(req, logEntry)
+    if err != nil {
+        return nil, err
+    }
+    
+    previous, err := self.checkReplay(req, logEntry)
+    if err != nil {
+        return nil, err
     }
     
     logEntry.Info("registering instance")
+    
+    record := &Record{
+        Environment:  req.Env,
+        Provider:     req.Provider,
+        Account:      req.Account,
+        AccountID:    self.config.AWS.AccountID(req.Account),
+        Region:       req.Region,
+        InstanceID:   req.InstanceID,
+        Role:         req.Role,
+        Policies:     policies,
+        AppRole:      appRole,
+        RegisteredAt: time.Now().UTC(),
+        RemoteAddr:   req.RemoteAddr,
+    }
+    
+    // delivered next to the credentials in the temp token's cubbyhole
+    extra, err := self.readSecrets(req, roleCfg.Secrets, logEntry)
+    if err != nil {
+        return nil, err
+    }
+    
+    if roleCfg.PKI != nil {
+        extra["pki"], err = self.issueCertificate(req, roleCfg.PKI, logEntry)
+        if err != nil {
+            return nil, err
+        }
+    }
+    
+    resp := &RegisterResponse{}
+    
+    if req.SSHHostPublicKey != "" {
+        if roleCfg.SSH != nil {
+            resp.SSHHostCertificate, err = self.signSSHHostKey(req, roleCfg.SSH, logEntry)
+            if err != nil {
+                return nil, err
+            }
+        } else {
+            logEntry.Debug("no ssh CA for role; not signing host key")
+        }
+    }
+    
+    // last, as it's the only one of these that needs cleaning up
+    if roleCfg.ConsulACL != nil {
+        record.ConsulACLToken, err = self.createConsulACLToken(req, roleCfg.ConsulACL, logEntry)
+        if err != nil {
+            return nil, err
+        }
+        
+        extra["consul_acl"] = map[string]interface{}{
+            "token": record.ConsulACLToken,
+        }
+    }
+    
+    switch self.config.Vault.RegistrationMode {
+        case config.RegistrationModeAppRole:
+            resp.RegistrationMode = config.RegistrationModeAppRole
+            resp.TempToken, record.SecretIDAccessor, err = self.createAppRoleSecretID(req, appRole, extra, metadata, &roleCfg.Token, logEntry)
+        
+        case config.RegistrationModeWrap:
+            resp.RegistrationMode = config.RegistrationModeWrap
+            permRequest, tokenRole := self.permTokenRequest(req, policies, metadata, roleCfg)
+            resp.TempToken, record.Accessor, err = self.createWrappedToken(permRequest, tokenRole, logEntry)
+        
+        default:
+            resp.RegistrationMode = config.RegistrationModeCubbyhole
+            permRequest, tokenRole := self.permTokenRequest(req, policies, metadata, roleCfg)
+            resp.TempToken, record.Accessor, err = self.createCubbyholeToken(req, permRequest, tokenRole, extra, metadata, &roleCfg.Token, logEntry)
+    }
+    
+    if err != nil {
+        if record.ConsulACLToken != "" {
+            destroyConsulACLToken(self.consulACL, record.ConsulACLToken, logEntry)
+        }
+        
+        return nil, err
+    }
+    
+    logEntry = logEntry.WithFields(record.logFields())
+    
+    logEntry.Debug("recording instance")
+    if previous != nil {
+        record.modifyIndex = previous.modifyIndex
+    }
+    
+    recorded, err := self.inventory.Put(record)
+    if err != nil || !recorded {
+        // unrecorded credentials can't be found again for deregistration
+        if err != nil {
+            logEntry.Errorf("error recording instance: %+v", err)
+        } else {
+            logEntry.Warn("instance registered concurrently")
+        }
+        
+        // failures are logged by revokeCredentials
+        revokeCredentials(self.vaultClient, self.consulACL, record, logEntry)
+        
+        if err != nil {
+            return nil, errors.New("unable to record instance")
+        }
+        
+        return nil, &ConflictError{"instance already registered"}
+    }
+    
+    if previous != nil {
+        // one set of credentials per instance
+        previousEntry := logEntry.WithFields(previous.logFields())
+        previousEntry.Info("revoking previous credentials")
+        revokeCredentials(self.vaultClient, self.consulACL, previous, previousEntry)
+    }
 
-    logEntry.Debug("creating perm token")    
-    permSecret, err := self.vaultClient.CreateToken(&vaultapi.TokenCreateRequest{
+    return resp, nil
+}
+
+// returns the request for the instance's perm token, and the token role to
+// create it through, if any
+func (self *Registrar) permTokenRequest(req *RegisterRequest, policies []string, metadata map[string]string, roleCfg *config.RoleConfig) (*vaultapi.TokenCreateRequest, string) {
+    permRequest := &vaultapi.TokenCreateRequest{
         DisplayName: fmt.Sprintf(
             "perm instance %s/%s/%s/%s/%s",
             req.Env,
@@ -68,43 +238,45 @@ func (self *Registrar) Register(req *RegisterRequest) (*RegisterResponse, error)
             req.Region,
             req.InstanceID,
         ),
-        Policies: req.Policies,
+        Policies: policies,
         Metadata: metadata,
-        Period: "72h",
+        Period: roleCfg.Token.Period.String(),
         NoParent: true,
-    })
-    
-    if err != nil {
-        logEntry.Errorf("error creating perm token: %+v", err)
-        return nil, errors.New("unable to create token")
     }
     
-    logEntry.Debug("creating temp token")    
-    tempSecret, err := self.vaultClient.CreateToken(&vaultapi.TokenCreateRequest{
-        DisplayName: fmt.Sprintf(
-            "temp instance %s/%s/%s/%s/%s",
-            req.Env,
-            req.Provider,
-            req.Account,
-            req.Region,
-            req.InstanceID,
-        ),
-        Metadata: metadata,
-        Lease: "15s",
-        NumUses: 2,
-    })
+    if roleCfg.Token.ExplicitMaxTTL.Duration > 0 {
+        permRequest.ExplicitMaxTTL = roleCfg.Token.ExplicitMaxTTL.String()
+    }
     
-    if err != nil {
-        logEntry.Errorf("error creating temp token: %+v", err)
-        return nil, errors.New("unable to create token")
+    envCfg, _ := self.config.Environment(req.Env)
+    tokenRole := envCfg.TokenRole
+    if tokenRole != "" {
+        // asking for an orphan or periodic token requires sudo, even through
+        // a role; the role's own orphan and period settings apply instead
+        permRequest.NoParent = false
+        permRequest.Period = ""
     }
+    
+    return permRequest, tokenRole
+}
 
-    logEntry.Debug("writing to cubbyhole/perm")    
-    self.vaultClient. // @todo check error!
-        WithToken(tempSecret.Auth.ClientToken).
-        WriteSecret("cubbyhole/perm", map[string]interface{}{
-            "payload": permSecret,
-        })
+// creates the perm token, through the token role if there is one
+func createPermToken(vaultClient interfaces.VaultClient, permRequest *vaultapi.TokenCreateRequest, tokenRole string) (*vaultapi.Secret, error) {
+    if tokenRole == "" {
+        return vaultClient.CreateToken(permRequest)
+    }
+    
+    return vaultClient.CreateTokenWithRole(permRequest, tokenRole)
+}
 
-    return &RegisterResponse{tempSecret.Auth.ClientToken}, nil
+// revokes tokens created during a failed registration so they aren't left
+// lying around.  failures are logged, but otherwise ignored; there's nothing
+// more we can do.
+func (self *Registrar) revokeTokens(logEntry *log.Entry, tokens ...string) {
+    for _, token := range tokens {
+        err := self.vaultClient.RevokeToken(token)
+        if err != nil {
+            logEntry.Errorf("error revoking token: %+v", err)
+        }
+    }
 }
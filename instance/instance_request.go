@@ -0,0 +1,10 @@
+package instance
+
+// identifies a registered instance
+type InstanceRequest struct {
+    Env        string
+    Provider   string
+    Account    string
+    Region     string
+    InstanceID string
+}
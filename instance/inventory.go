@@ -0,0 +1,164 @@
+package instance
+
+import (
+    log "github.com/Sirupsen/logrus"
+    "fmt"
+    "time"
+    "path"
+    "encoding/json"
+    
+    "github.com/bluestatedigital/centralbooking/interfaces"
+    
+    consulapi "github.com/hashicorp/consul/api"
+)
+
+// what we know about a registered instance.  never contains a Vault token.
+type Record struct {
+    Environment string    `json:"environment"`
+    Provider    string    `json:"provider"`
+    Account     string    `json:"account"`
+    AccountID   string    `json:"account_id"`
+    Region      string    `json:"region"`
+    InstanceID  string    `json:"instance_id"`
+    Role        string    `json:"role"`
+    Policies    []string  `json:"policies"`
+    
+    // accessor of the perm token; can be used to look up or revoke the token
+    Accessor    string    `json:"accessor"`
+    
+    // in "approle" mode, the path of the AppRole role and the accessor of the
+    // SecretID issued instead of a perm token
+    AppRole          string `json:"approle,omitempty"`
+    SecretIDAccessor string `json:"secret_id_accessor,omitempty"`
+    
+    // ID of the instance's Consul ACL token, if it was given one.  Consul has
+    // no accessors; the ID is needed to destroy the token, and is the token
+    // itself.
+    ConsulACLToken   string `json:"consul_acl_token,omitempty"`
+    
+    RegisteredAt time.Time `json:"registered_at"`
+    RemoteAddr   string    `json:"remote_addr"`
+    
+    // set by an operator to let the instance register again
+    AllowReregistration bool `json:"allow_reregistration,omitempty"`
+    
+    // Consul's ModifyIndex for the record when it was read; 0 if new
+    modifyIndex uint64
+}
+
+// identifies the record's credentials in log entries
+func (self *Record) logFields() log.Fields {
+    if self.SecretIDAccessor != "" {
+        return log.Fields{ "secret_id_accessor": self.SecretIDAccessor }
+    }
+    
+    return log.Fields{ "accessor": self.Accessor }
+}
+
+// instance records stored in Consul's KV store under
+// <prefix>/<provider>/<account_id>/<instance_id>.  the key is made up only of
+// fields verified against the identity document, so an instance can't get a
+// fresh record by claiming another environment or account name.
+type inventory struct {
+    kv     interfaces.ConsulKV
+    prefix string
+}
+
+func newInventory(kv interfaces.ConsulKV, prefix string) *inventory {
+    return &inventory{
+        kv:     kv,
+        prefix: prefix,
+    }
+}
+
+func (self *inventory) key(provider, accountID, instanceID string) (string, error) {
+    for _, component := range []string{provider, accountID, instanceID} {
+        if !validName(component) {
+            return "", fmt.Errorf("invalid key component %q", component)
+        }
+    }
+    
+    return path.Join(self.prefix, provider, accountID, instanceID), nil
+}
+
+// writes the record if it hasn't changed since it was read, or if it's new
+// and no record exists for the instance.  returns false if the check failed.
+func (self *inventory) Put(record *Record) (bool, error) {
+    key, err := self.key(record.Provider, record.AccountID, record.InstanceID)
+    if err != nil {
+        return false, err
+    }
+    
+    recordBytes, err := json.Marshal(record)
+    if err != nil {
+        return false, err
+    }
+    
+    ok, _, err := self.kv.CAS(&consulapi.KVPair{
+        Key:         key,
+        Value:       recordBytes,
+        ModifyIndex: record.modifyIndex,
+    }, nil)
+    
+    return ok, err
+}
+
+// returns the record for the instance, or nil if there isn't one
+func (self *inventory) Get(provider, accountID, instanceID string) (*Record, error) {
+    key, err := self.key(provider, accountID, instanceID)
+    if err != nil {
+        return nil, err
+    }
+    
+    kvPair, _, err := self.kv.Get(key, nil)
+    if err != nil {
+        return nil, err
+    }
+    
+    if kvPair == nil {
+        return nil, nil
+    }
+    
+    record := &Record{}
+    err = json.Unmarshal(kvPair.Value, record)
+    if err != nil {
+        return nil, fmt.Errorf("unable to decode %s: %s", kvPair.Key, err)
+    }
+    
+    record.modifyIndex = kvPair.ModifyIndex
+    
+    return record, nil
+}
+
+func (self *inventory) Delete(record *Record) error {
+    key, err := self.key(record.Provider, record.AccountID, record.InstanceID)
+    if err != nil {
+        return err
+    }
+    
+    _, err = self.kv.Delete(key, nil)
+    
+    return err
+}
+
+// returns all records.  records that can't be decoded are logged and skipped.
+func (self *inventory) List() ([]*Record, error) {
+    kvPairs, _, err := self.kv.List(self.prefix + "/", nil)
+    if err != nil {
+        return nil, err
+    }
+    
+    records := make([]*Record, 0, len(kvPairs))
+    for _, kvPair := range kvPairs {
+        record := &Record{}
+        err = json.Unmarshal(kvPair.Value, record)
+        if err != nil {
+            log.Errorf("unable to decode %s: %s", kvPair.Key, err)
+            continue
+        }
+        
+        records = append(records, record)
+    }
+    
+    return records, nil
+}
@@ -0,0 +1,26 @@
+package instance
+
+import (
+    "regexp"
+    "strings"
+)
+
+var validNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// true if name is safe to use as a path component
+func validName(name string) bool {
+    return validNamePattern.MatchString(name) && name != "." && name != ".."
+}
+
+
+// true if any of the rendered values would split into more than one when
+// joined into a comma-separated list
+func containsComma(values []string) bool {
+    for _, value := range values {
+        if strings.Contains(value, ",") {
+            return true
+        }
+    }
+    
+    return false
+}
@@ -0,0 +1,17 @@
+package instance
+
+import (
+    log "github.com/Sirupsen/logrus"
+)
+
+// registrations must come from the networks allowed for the environment and
+// account
+func (self *Registrar) verifyRemoteAddr(req *RegisterRequest, logEntry *log.Entry) error {
+    envCfg, _ := self.config.Environment(req.Env)
+    if !envCfg.AllowsAddress(req.Account, req.RemoteAddr) {
+        logEntry.Warn("remote address not in allowed networks")
+        return &ValidationError{"remote address not allowed"}
+    }
+    
+    return nil
+}
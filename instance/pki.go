@@ -0,0 +1,56 @@
+package instance
+
+import (
+    log "github.com/Sirupsen/logrus"
+    "errors"
+    "strings"
+    
+    "github.com/bluestatedigital/centralbooking/config"
+)
+
+// issues the instance's TLS certificate.  returns the certificate, private
+// key and CA chain, as returned by the PKI backend.
+func (self *Registrar) issueCertificate(req *RegisterRequest, pkiCfg *config.PKIConfig, logEntry *log.Entry) (map[string]interface{}, error) {
+    logEntry = logEntry.WithField("pki_path", pkiCfg.IssuePath())
+    
+    commonName, altNames, ipSANs, err := pkiCfg.Render(req.vars())
+    if err != nil {
+        logEntry.Errorf("error rendering certificate names: %+v", err)
+        return nil, errors.New("unable to issue certificate")
+    }
+    
+    // the PKI backend takes these as comma-separated lists
+    if containsComma(altNames) || containsComma(ipSANs) {
+        logEntry.Warnf("rendered certificate names contain commas: %q %q", altNames, ipSANs)
+        return nil, &ValidationError{"invalid certificate names"}
+    }
+    
+    params := map[string]interface{}{
+        "common_name": commonName,
+    }
+    
+    if len(altNames) > 0 {
+        params["alt_names"] = strings.Join(altNames, ",")
+    }
+    
+    if len(ipSANs) > 0 {
+        params["ip_sans"] = strings.Join(ipSANs, ",")
+    }
+    
+    if pkiCfg.TTL.Duration > 0 {
+        params["ttl"] = pkiCfg.TTL.String()
+    }
+    
+    logEntry.WithField("common_name", commonName).Debug("issuing certificate")
+    secret, err := self.vaultClient.WriteSecret(pkiCfg.IssuePath(), params)
+    if err == nil && (secret == nil || secret.Data == nil) {
+        err = errors.New("no certificate in response")
+    }
+    
+    if err != nil {
+        logEntry.Errorf("error issuing certificate: %+v", err)
+        return nil, errors.New("unable to issue certificate")
+    }
+    
+    return secret.Data, nil
+}
@@ -0,0 +1,42 @@
+package instance
+
+import (
+    "fmt"
+    
+    "github.com/bluestatedigital/centralbooking/config"
+)
+
+// returns the policies to attach to the perm token.  requested policies must
+// be allowed by the instance's role config, which may be nil if none matched;
+// if none are requested, the role's default policies are used.
+func (self *Registrar) resolvePolicies(req *RegisterRequest, roleCfg *config.RoleConfig) ([]string, error) {
+    // disallow creating tokens with the root policy, regardless of config
+    for _, p := range req.Policies {
+        if p == "root" {
+            return nil, &ValidationError{"illegal policy"}
+        }
+    }
+    
+    if roleCfg == nil {
+        return nil, &ValidationError{"no policies allowed for role"}
+    }
+    
+    if len(req.Policies) == 0 {
+        policies := roleCfg.DefaultPolicies()
+        
+        // at least one policy must be granted
+        if len(policies) == 0 {
+            return nil, &ValidationError{"no policies specified"}
+        }
+        
+        return policies, nil
+    }
+    
+    for _, p := range req.Policies {
+        if ! roleCfg.AllowsPolicy(p) {
+            return nil, &ValidationError{fmt.Sprintf("policy %s not allowed for role", p)}
+        }
+    }
+    
+    return req.Policies, nil
+}
@@ -0,0 +1,217 @@
+package instance
+
+import (
+    log "github.com/Sirupsen/logrus"
+    "time"
+    "errors"
+    "fmt"
+    
+    "github.com/bluestatedigital/centralbooking/config"
+    "github.com/bluestatedigital/centralbooking/interfaces"
+    
+    "github.com/aws/aws-sdk-go/aws"
+    "github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// EC2 accepts up to 200 filter values; stay well under
+const reconcileBatchSize = 100
+
+// EC2 is eventually consistent; don't judge instances that registered very
+// recently
+const reconcileMinRecordAge = 5 * time.Minute
+
+var errReconcileStopped = errors.New("reconciliation stopped")
+
+// where a batch of instances is described
+type ec2Location struct {
+    accountID string
+    region    string
+}
+
+// revokes the perm tokens of registered instances that have terminated or no
+// longer exist
+type Reconciler struct {
+    vaultClient  interfaces.VaultClient
+    ec2Describer interfaces.EC2Describer
+    consulACL    interfaces.ConsulACL
+    inventory    *inventory
+    config       *config.Config
+}
+
+func NewReconciler(vaultClient interfaces.VaultClient, ec2Describer interfaces.EC2Describer, consulKV interfaces.ConsulKV, consulACL interfaces.ConsulACL, cfg *config.Config) *Reconciler {
+    return &Reconciler{
+        vaultClient:  vaultClient,
+        ec2Describer: ec2Describer,
+        consulACL:    consulACL,
+        inventory:    newInventory(consulKV, cfg.Consul.InstancePrefix),
+        config:       cfg,
+    }
+}
+
+// reconciles every reconciler.interval until stop is closed
+func (self *Reconciler) Run(stop <-chan struct{}) {
+    ticker := time.NewTicker(self.config.Reconciler.Interval.Duration)
+    defer ticker.Stop()
+    
+    for {
+        select {
+            case <-stop:
+                return
+            
+            case <-ticker.C:
+                err := self.Reconcile(stop)
+                if err == errReconcileStopped {
+                    return
+                } else if err != nil {
+                    log.Errorf("reconciliation failed: %s", err)
+                }
+        }
+    }
+}
+
+// checks every registered AWS instance against EC2, revoking those that are
+// gone.  instances whose state can't be determined are left alone.
+func (self *Reconciler) Reconcile(stop <-chan struct{}) error {
+    records, err := self.inventory.List()
+    if err != nil {
+        return err
+    }
+    
+    log.Infof("reconciling %d registered instances", len(records))
+    
+    // limits the rate of EC2 API calls
+    throttle := time.NewTicker(time.Duration(float64(time.Second) / self.config.Reconciler.RequestsPerSecond))
+    defer throttle.Stop()
+    
+    // instances are described in the account that owns them; an instance
+    // can't be judged missing by looking for it anywhere else
+    byLocation := map[ec2Location][]*Record{}
+    for _, record := range records {
+        if record.Provider != "aws" {
+            continue
+        }
+        
+        if record.AccountID == "" {
+            continue
+        }
+        
+        if time.Since(record.RegisteredAt) < reconcileMinRecordAge {
+            continue
+        }
+        
+        loc := ec2Location{record.AccountID, record.Region}
+        byLocation[loc] = append(byLocation[loc], record)
+    }
+    
+    for loc, locRecords := range byLocation {
+        for len(locRecords) > 0 {
+            batch := locRecords
+            if len(batch) > reconcileBatchSize {
+                batch = batch[:reconcileBatchSize]
+            }
+            locRecords = locRecords[len(batch):]
+            
+            err = self.reconcileBatch(loc, batch, throttle.C, stop)
+            if err == errReconcileStopped {
+                return err
+            } else if err != nil {
+                log.Errorf("unable to describe instances in account %s, %s: %s", loc.accountID, loc.region, err)
+            }
+        }
+    }
+    
+    return nil
+}
+
+func (self *Reconciler) reconcileBatch(loc ec2Location, records []*Record, throttle <-chan time.Time, stop <-chan struct{}) error {
+    instanceIDs := make([]*string, 0, len(records))
+    for _, record := range records {
+        instanceIDs = append(instanceIDs, aws.String(record.InstanceID))
+    }
+    
+    // filtering, rather than passing InstanceIds, means missing instances are
+    // simply absent from the result instead of failing the whole request
+    input := &ec2.DescribeInstancesInput{
+        Filters: []*ec2.Filter{
+            &ec2.Filter{
+                Name:   aws.String("instance-id"),
+                Values: instanceIDs,
+            },
+        },
+    }
+    
+    // instance id -> state
+    states := map[string]string{}
+    
+    for {
+        select {
+            case <-stop:
+                return errReconcileStopped
+            
+            case <-throttle:
+        }
+        
+        output, err := self.ec2Describer.DescribeInstances(loc.accountID, loc.region, input)
+        if err != nil {
+            return err
+        }
+        
+        for _, reservation := range output.Reservations {
+            // the describer should only ever use the owning account's
+            // credentials; if not, nothing it didn't return can be trusted
+            // to be missing
+            if reservation.OwnerId != nil && aws.StringValue(reservation.OwnerId) != loc.accountID {
+                return fmt.Errorf("instances described in account %s belong to %s", loc.accountID, aws.StringValue(reservation.OwnerId))
+            }
+            
+            for _, inst := range reservation.Instances {
+                if inst.State != nil {
+                    states[aws.StringValue(inst.InstanceId)] = aws.StringValue(inst.State.Name)
+                }
+            }
+        }
+        
+        if aws.StringValue(output.NextToken) == "" {
+            break
+        }
+        
+        input.NextToken = output.NextToken
+    }
+    
+    for _, record := range records {
+        logEntry := log.WithFields(log.Fields{
+            "environment": record.Environment,
+            "provider":    record.Provider,
+            "account":     record.Account,
+            "region":      record.Region,
+            "instance_id": record.InstanceID,
+            "role":        record.Role,
+        })
+        
+        state, found := states[record.InstanceID]
+        if !found {
+            state = "missing"
+        }
+        
+        logEntry = logEntry.WithField("state", state)
+        
+        if found && state != ec2.InstanceStateNameTerminated {
+            logEntry.Debug("instance still exists")
+            continue
+        }
+        
+        logEntry = logEntry.WithFields(record.logFields())
+        
+        if self.config.Reconciler.DryRun {
+            logEntry.Info("dry run; would revoke credentials")
+            continue
+        }
+        
+        logEntry.Info("revoking credentials")
+        
+        // failures are logged by revokeRecord; try again next time
+        revokeRecord(self.vaultClient, self.consulACL, self.inventory, record, logEntry)
+    }
+    
+    return nil
+}
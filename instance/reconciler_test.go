@@ -0,0 +1,239 @@
+package instance_test
+
+import (
+    "os"
+    "fmt"
+    "time"
+    "bytes"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "encoding/json"
+    
+    "github.com/bluestatedigital/centralbooking/config"
+    "github.com/bluestatedigital/centralbooking/helpers"
+    "github.com/bluestatedigital/centralbooking/instance"
+    "github.com/bluestatedigital/centralbooking/interfaces"
+    
+    consulapi "github.com/hashicorp/consul/api"
+
+    . "github.com/onsi/ginkgo"
+    . "github.com/onsi/gomega"
+    
+    "github.com/stretchr/testify/mock"
+)
+
+// a minimal EC2 API that knows about the given instances, all in account
+// 123456789012.  it doubles as the STS API for the same account.
+func fakeEC2(states map[string]string, requests *int, fail *bool) *httptest.Server {
+    return httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+        req.ParseForm()
+        
+        if req.Form.Get("Action") == "GetCallerIdentity" {
+            resp.Header().Set("Content-Type", "text/xml")
+            resp.Write([]byte(`<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+    <GetCallerIdentityResult>
+        <Arn>arn:aws:iam::123456789012:user/centralbooking</Arn>
+        <UserId>AIDACKCEVSQ6C2EXAMPLE</UserId>
+        <Account>123456789012</Account>
+    </GetCallerIdentityResult>
+    <ResponseMetadata><RequestId>01234567-89ab-cdef-0123-456789abcdef</RequestId></ResponseMetadata>
+</GetCallerIdentityResponse>`))
+            return
+        }
+        
+        *requests++
+        
+        if *fail {
+            resp.WriteHeader(http.StatusServiceUnavailable)
+            resp.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<Response><Errors><Error><Code>Unavailable</Code><Message>try again</Message></Error></Errors><RequestID>x</RequestID></Response>`))
+            return
+        }
+        
+        var instances bytes.Buffer
+        for i := 1; req.Form.Get(fmt.Sprintf("Filter.1.Value.%d", i)) != ""; i++ {
+            instanceID := req.Form.Get(fmt.Sprintf("Filter.1.Value.%d", i))
+            
+            if state, ok := states[instanceID]; ok {
+                fmt.Fprintf(&instances, `
+                <item>
+                    <instanceId>%s</instanceId>
+                    <instanceState><code>0</code><name>%s</name></instanceState>
+                </item>`, instanceID, state)
+            }
+        }
+        
+        resp.Header().Set("Content-Type", "text/xml")
+        fmt.Fprintf(resp, `<?xml version="1.0" encoding="UTF-8"?>
+<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+    <requestId>8f7724cf-496f-496e-8fe3-example</requestId>
+    <reservationSet>
+        <item>
+            <reservationId>r-1234567890abcdef0</reservationId>
+            <ownerId>123456789012</ownerId>
+            <instancesSet>%s
+            </instancesSet>
+        </item>
+    </reservationSet>
+</DescribeInstancesResponse>`, instances.String())
+    }))
+}
+
+var _ = Describe("Reconciler", func() {
+    var reconciler *instance.Reconciler
+    var cfg *config.Config
+    
+    var server *httptest.Server
+    var ec2Requests int
+    var ec2Fail bool
+    
+    var mockVaultClient interfaces.MockVaultClient
+    var mockConsulKV interfaces.MockConsulKV
+    var mockConsulACL interfaces.MockConsulACL
+    
+    kvPair := func(instanceID string, provider string, accountID string, registeredAt time.Time) *consulapi.KVPair {
+        recordBytes, err := json.Marshal(&instance.Record{
+            Environment:  "dev",
+            Provider:     provider,
+            Account:      "gen",
+            AccountID:    accountID,
+            Region:       "us-east-1",
+            InstanceID:   instanceID,
+            Role:         "cluster-server",
+            Accessor:     instanceID + "-accessor",
+            RegisteredAt: registeredAt,
+        })
+        Expect(err).To(BeNil())
+        
+        return &consulapi.KVPair{
+            Key:   "centralbooking/instances/" + provider + "/" + accountID + "/" + instanceID,
+            Value: recordBytes,
+        }
+    }
+    
+    expectRevoked := func(instanceID string) {
+        mockVaultClient.On("RevokeAccessor", instanceID + "-accessor").Return(nil).Once()
+        mockConsulKV.
+            On("Delete", "centralbooking/instances/aws/123456789012/" + instanceID, mock.AnythingOfType("*api.WriteOptions")).
+            Return(nil, nil).
+            Once()
+    }
+    
+    BeforeEach(func() {
+        os.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+        os.Setenv("AWS_SECRET_ACCESS_KEY", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+        
+        ec2Requests = 0
+        ec2Fail = false
+        server = fakeEC2(map[string]string{
+            "i-running":    "running",
+            "i-stopped":    "stopped",
+            "i-terminated": "terminated",
+        }, &ec2Requests, &ec2Fail)
+        
+        ec2Describer, err := helpers.NewEC2Describer(server.URL, server.URL, nil)
+        Expect(err).To(BeNil())
+        
+        mockVaultClient = interfaces.MockVaultClient{}
+        mockConsulKV = interfaces.MockConsulKV{}
+        mockConsulACL = interfaces.MockConsulACL{}
+        
+        cfg = &config.Config{
+            Consul: config.ConsulConfig{
+                InstancePrefix: "centralbooking/instances",
+            },
+            Reconciler: config.ReconcilerConfig{
+                Interval:          config.Duration{ Duration: time.Minute },
+                RequestsPerSecond: 1000,
+            },
+        }
+        
+        reconciler = instance.NewReconciler(&mockVaultClient, ec2Describer, &mockConsulKV, &mockConsulACL, cfg)
+        
+        registeredAt := time.Now().Add(-time.Hour)
+        
+        mockConsulKV.
+            On("List", "centralbooking/instances/", mock.AnythingOfType("*api.QueryOptions")).
+            Return(consulapi.KVPairs{
+                kvPair("i-running", "aws", "123456789012", registeredAt),
+                kvPair("i-stopped", "aws", "123456789012", registeredAt),
+                kvPair("i-terminated", "aws", "123456789012", registeredAt),
+                kvPair("i-missing", "aws", "123456789012", registeredAt),
+                kvPair("i-brandnew", "aws", "123456789012", time.Now()),
+                kvPair("i-elsewhere", "aws", "210987654321", registeredAt),
+                kvPair("droplet-1234", "digitalocean", "123456789012", registeredAt),
+            }, nil, nil)
+    })
+    
+    AfterEach(func() {
+        server.Close()
+        
+        os.Unsetenv("AWS_ACCESS_KEY_ID")
+        os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+    })
+    
+    It("revokes terminated and missing instances", func() {
+        expectRevoked("i-terminated")
+        expectRevoked("i-missing")
+        
+        Expect(reconciler.Reconcile(nil)).To(BeNil())
+        
+        // one batch for the region
+        Expect(ec2Requests).To(Equal(1))
+        
+        mockVaultClient.AssertExpectations(GinkgoT())
+        mockConsulKV.AssertExpectations(GinkgoT())
+    })
+    
+    It("leaves instances in other accounts alone", func() {
+        expectRevoked("i-terminated")
+        expectRevoked("i-missing")
+        
+        Expect(reconciler.Reconcile(nil)).To(BeNil())
+        
+        // there are no credentials for 210987654321, so it isn't described
+        Expect(ec2Requests).To(Equal(1))
+        
+        mockVaultClient.AssertNotCalled(GinkgoT(), "RevokeAccessor", "i-elsewhere-accessor")
+        mockConsulKV.AssertNotCalled(GinkgoT(), "Delete", "centralbooking/instances/aws/210987654321/i-elsewhere", mock.Anything)
+    })
+    
+    It("only logs in dry-run mode", func() {
+        cfg.Reconciler.DryRun = true
+        
+        Expect(reconciler.Reconcile(nil)).To(BeNil())
+        
+        mockVaultClient.AssertNotCalled(GinkgoT(), "RevokeAccessor", mock.Anything)
+        mockConsulKV.AssertNotCalled(GinkgoT(), "Delete", mock.Anything, mock.Anything)
+    })
+    
+    It("leaves instances alone if EC2 is unavailable", func() {
+        ec2Fail = true
+        
+        Expect(reconciler.Reconcile(nil)).To(BeNil())
+        Expect(ec2Requests).To(BeNumerically(">", 0))
+        
+        mockVaultClient.AssertNotCalled(GinkgoT(), "RevokeAccessor", mock.Anything)
+        mockConsulKV.AssertNotCalled(GinkgoT(), "Delete", mock.Anything, mock.Anything)
+    })
+    
+    It("keeps the record if the token can't be revoked", func() {
+        mockVaultClient.On("RevokeAccessor", "i-terminated-accessor").Return(errors.New("permission denied")).Once()
+        expectRevoked("i-missing")
+        
+        Expect(reconciler.Reconcile(nil)).To(BeNil())
+        
+        mockConsulKV.AssertNotCalled(GinkgoT(), "Delete", "centralbooking/instances/aws/123456789012/i-terminated", mock.Anything)
+    })
+    
+    It("stops when asked", func() {
+        cfg.Reconciler.RequestsPerSecond = 0.001
+        
+        stop := make(chan struct{})
+        close(stop)
+        
+        Expect(reconciler.Reconcile(stop)).NotTo(BeNil())
+        Expect(ec2Requests).To(Equal(0))
+    })
+})
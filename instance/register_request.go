@@ -1,5 +1,9 @@
 package instance
 
+import (
+    "github.com/bluestatedigital/centralbooking/config"
+)
+
 type RegisterRequest struct {
     Env        string
     Provider   string
@@ -9,5 +13,25 @@ type RegisterRequest struct {
     Role       string
     Policies   []string
     
+    // EC2 instance identity document and its (decoded) PKCS7 signature
+    IdentityDocument  []byte
+    IdentitySignature []byte
+    
     RemoteAddr string
+    
+    // OpenSSH-format host public key to sign; optional
+    SSHHostPublicKey string
+}
+
+// the instance's details, for rendering templates in the config
+func (self *RegisterRequest) vars() *config.InstanceVars {
+    return &config.InstanceVars{
+        Environment: self.Env,
+        Provider:    self.Provider,
+        Account:     self.Account,
+        Region:      self.Region,
+        InstanceID:  self.InstanceID,
+        Role:        self.Role,
+        Address:     self.RemoteAddr,
+    }
 }
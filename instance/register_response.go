@@ -2,4 +2,12 @@ package instance
 
 type RegisterResponse struct {
     TempToken string
+    
+    // one of the config.RegistrationMode* constants; tells the instance how
+    // to exchange the temp token for the perm token
+    RegistrationMode string
+    
+    // the instance's signed SSH host key, if it provided one and its role
+    // has an SSH CA
+    SSHHostCertificate string
 }
@@ -0,0 +1,63 @@
+package instance
+
+import (
+    log "github.com/Sirupsen/logrus"
+    "errors"
+)
+
+// an instance may only register once, so a stolen identity document can't be
+// used to mint more tokens.  returns the instance's existing record if an
+// operator has allowed it to register again.
+func (self *Registrar) checkReplay(req *RegisterRequest, logEntry *log.Entry) (*Record, error) {
+    // the account ID matches the identity document by now
+    record, err := self.inventory.Get(req.Provider, self.config.AWS.AccountID(req.Account), req.InstanceID)
+    if err != nil {
+        logEntry.Errorf("error retrieving instance record: %+v", err)
+        return nil, errors.New("unable to retrieve instance record")
+    }
+    
+    if record == nil {
+        return nil, nil
+    }
+    
+    if !record.AllowReregistration {
+        logEntry.WithField("registered_at", record.RegisteredAt).Warn("instance already registered")
+        return nil, &ConflictError{"instance already registered"}
+    }
+    
+    logEntry.Info("re-registration allowed")
+    
+    return record, nil
+}
+
+// lets a registered instance register once more, replacing its perm token
+func (self *Registrar) AllowReregistration(req *InstanceRequest) error {
+    logEntry := log.WithFields(log.Fields{
+        "environment": req.Env,
+        "provider":    req.Provider,
+        "account":     req.Account,
+        "region":      req.Region,
+        "instance_id": req.InstanceID,
+    })
+    
+    record, err := self.findRecord(req, logEntry)
+    if err != nil {
+        return err
+    }
+    
+    record.AllowReregistration = true
+    
+    ok, err := self.inventory.Put(record)
+    if err != nil {
+        logEntry.Errorf("error updating instance record: %+v", err)
+        return errors.New("unable to update instance record")
+    }
+    
+    if !ok {
+        return &ConflictError{"instance record changed; try again"}
+    }
+    
+    logEntry.Info("allowed re-registration")
+    
+    return nil
+}
@@ -0,0 +1,41 @@
+package instance
+
+import (
+    log "github.com/Sirupsen/logrus"
+    "errors"
+    
+    "github.com/bluestatedigital/centralbooking/config"
+)
+
+// reads the role's bootstrap secrets with our own token, so the instance's
+// perm token doesn't need access to them.  returns their data keyed by name.
+func (self *Registrar) readSecrets(req *RegisterRequest, secrets []config.SecretConfig, logEntry *log.Entry) (map[string]map[string]interface{}, error) {
+    data := make(map[string]map[string]interface{}, len(secrets))
+    
+    for i := range secrets {
+        secretEntry := logEntry.WithField("secret", secrets[i].Name)
+        
+        path, err := secrets[i].Render(req.vars())
+        if err != nil {
+            secretEntry.Errorf("error rendering secret path: %+v", err)
+            return nil, errors.New("unable to read secrets")
+        }
+        
+        secretEntry = secretEntry.WithField("path", path)
+        
+        secretEntry.Debug("reading secret")
+        secret, err := self.vaultClient.ReadSecret(path)
+        if err == nil && (secret == nil || secret.Data == nil) {
+            err = errors.New("no such secret")
+        }
+        
+        if err != nil {
+            secretEntry.Errorf("error reading secret: %+v", err)
+            return nil, errors.New("unable to read secrets")
+        }
+        
+        data[secrets[i].Name] = secret.Data
+    }
+    
+    return data, nil
+}
@@ -0,0 +1,69 @@
+package instance
+
+import (
+    log "github.com/Sirupsen/logrus"
+    "errors"
+    "strings"
+    "encoding/base64"
+    
+    "github.com/bluestatedigital/centralbooking/config"
+)
+
+// loosely checks that the key looks like "<type> <base64 key> [comment]", so
+// garbage is rejected as the client's fault
+func validSSHPublicKey(key string) bool {
+    fields := strings.Fields(key)
+    if len(fields) < 2 || !strings.HasPrefix(fields[0], "ssh-") && !strings.HasPrefix(fields[0], "ecdsa-") {
+        return false
+    }
+    
+    _, err := base64.StdEncoding.DecodeString(fields[1])
+    
+    return err == nil
+}
+
+// signs the instance's host key.  returns the host certificate.
+func (self *Registrar) signSSHHostKey(req *RegisterRequest, sshCfg *config.SSHConfig, logEntry *log.Entry) (string, error) {
+    logEntry = logEntry.WithField("ssh_path", sshCfg.SignPath())
+    
+    principals, err := sshCfg.Render(req.vars())
+    if err != nil {
+        logEntry.Errorf("error rendering ssh principals: %+v", err)
+        return "", errors.New("unable to sign ssh host key")
+    }
+    
+    // the SSH backend takes these as a comma-separated list
+    if containsComma(principals) {
+        logEntry.Warnf("rendered ssh principals contain commas: %q", principals)
+        return "", &ValidationError{"invalid ssh principals"}
+    }
+    
+    params := map[string]interface{}{
+        "public_key":       req.SSHHostPublicKey,
+        "cert_type":        "host",
+        "valid_principals": strings.Join(principals, ","),
+    }
+    
+    if sshCfg.TTL.Duration > 0 {
+        params["ttl"] = sshCfg.TTL.String()
+    }
+    
+    logEntry.Debug("signing ssh host key")
+    secret, err := self.vaultClient.WriteSecret(sshCfg.SignPath(), params)
+    
+    var signedKey string
+    if err == nil && secret != nil {
+        signedKey, _ = secret.Data["signed_key"].(string)
+    }
+    
+    if err == nil && signedKey == "" {
+        err = errors.New("no signed key in response")
+    }
+    
+    if err != nil {
+        logEntry.Errorf("error signing ssh host key: %+v", err)
+        return "", errors.New("unable to sign ssh host key")
+    }
+    
+    return signedKey, nil
+}
@@ -0,0 +1,74 @@
+package instance
+
+import (
+    "fmt"
+    "sort"
+    "encoding/json"
+    
+    "github.com/bluestatedigital/centralbooking/config"
+)
+
+// checks that the environments' token roles create orphan, periodic tokens.
+// perm tokens created through a role can't ask for either without sudo, so
+// without them the tokens would be children of centralbooking's own token and
+// expire with their default TTL.
+func (self *Registrar) CheckTokenRoles() error {
+    // AppRole mode issues no perm tokens
+    if self.config.Vault.RegistrationMode == config.RegistrationModeAppRole {
+        return nil
+    }
+    
+    roles := map[string]bool{}
+    for _, env := range self.config.Environments {
+        if env.TokenRole != "" {
+            roles[env.TokenRole] = true
+        }
+    }
+    
+    names := make([]string, 0, len(roles))
+    for name := range roles {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    
+    for _, name := range names {
+        secret, err := self.vaultClient.ReadSecret("auth/token/roles/" + name)
+        if err != nil {
+            return fmt.Errorf("unable to read token role %s: %s", name, err)
+        }
+        
+        if secret == nil || secret.Data == nil {
+            return fmt.Errorf("no such token role %s", name)
+        }
+        
+        if orphan, _ := secret.Data["orphan"].(bool); !orphan {
+            return fmt.Errorf("token role %s does not create orphan tokens", name)
+        }
+        
+        period := secret.Data["period"]
+        if period == nil {
+            // renamed in later Vault versions
+            period = secret.Data["token_period"]
+        }
+        
+        if !positiveSeconds(period) {
+            return fmt.Errorf("token role %s does not create periodic tokens", name)
+        }
+    }
+    
+    return nil
+}
+
+// true if v is a JSON number of seconds greater than zero
+func positiveSeconds(v interface{}) bool {
+    switch n := v.(type) {
+        case json.Number:
+            seconds, err := n.Int64()
+            return err == nil && seconds > 0
+        
+        case float64:
+            return n > 0
+    }
+    
+    return false
+}
@@ -0,0 +1,39 @@
+package instance
+
+import (
+    log "github.com/Sirupsen/logrus"
+    "errors"
+    
+    vaultapi "github.com/hashicorp/vault/api"
+)
+
+// creates the perm token as a response-wrapped secret.  returns the wrapping
+// token, which the instance exchanges via sys/wrapping/unwrap, and the perm
+// token's accessor.
+func (self *Registrar) createWrappedToken(permRequest *vaultapi.TokenCreateRequest, tokenRole string, logEntry *log.Entry) (string, string, error) {
+    logEntry.Debug("creating wrapped perm token")
+    permSecret, err := createPermToken(
+        self.vaultClient.WithWrapTTL(self.config.Vault.WrapTTL.String()),
+        permRequest,
+        tokenRole,
+    )
+    
+    if err != nil {
+        logEntry.Errorf("error creating perm token: %+v", err)
+        return "", "", errors.New("unable to create token")
+    }
+    
+    if permSecret.WrapInfo == nil {
+        // Vault didn't honor the wrap request; don't hand out an unwrapped
+        // perm token
+        logEntry.Error("perm token response was not wrapped")
+        
+        if permSecret.Auth != nil {
+            self.revokeTokens(logEntry, permSecret.Auth.ClientToken)
+        }
+        
+        return "", "", errors.New("unable to create token")
+    }
+    
+    return permSecret.WrapInfo.Token, permSecret.WrapInfo.WrappedAccessor, nil
+}
@@ -0,0 +1,10 @@
+package interfaces
+
+import (
+    "github.com/hashicorp/consul/api"
+)
+
+type ConsulACL interface {
+    Create(acl *api.ACLEntry, q *api.WriteOptions) (string, *api.WriteMeta, error)
+    Destroy(id string, q *api.WriteOptions) (*api.WriteMeta, error)
+}
@@ -0,0 +1,5 @@
+package interfaces
+
+type ConsulAgent interface {
+    Self() (map[string]map[string]interface{}, error)
+}
@@ -0,0 +1,12 @@
+package interfaces
+
+import (
+    "github.com/hashicorp/consul/api"
+)
+
+type ConsulKV interface {
+    Get(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error)
+    CAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error)
+    List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error)
+    Delete(key string, w *api.WriteOptions) (*api.WriteMeta, error)
+}
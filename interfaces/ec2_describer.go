@@ -0,0 +1,9 @@
+package interfaces
+
+import (
+    "github.com/aws/aws-sdk-go/service/ec2"
+)
+
+type EC2Describer interface {
+    DescribeInstances(accountID string, region string, input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error)
+}
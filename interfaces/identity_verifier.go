@@ -0,0 +1,9 @@
+package interfaces
+
+import (
+    "github.com/aws/aws-sdk-go/aws/ec2metadata"
+)
+
+type IdentityVerifier interface {
+    Verify(document, signature []byte) (*ec2metadata.EC2InstanceIdentityDocument, error)
+}
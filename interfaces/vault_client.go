@@ -5,6 +5,14 @@ import "github.com/hashicorp/vault/api"
 type VaultClient interface {
     GetEndpoint() string
     WithToken(token string) VaultClient
+    WithWrapTTL(ttl string) VaultClient
     CreateToken(opts *api.TokenCreateRequest) (*api.Secret, error)
+    CreateTokenWithRole(opts *api.TokenCreateRequest, role string) (*api.Secret, error)
+    ReadSecret(path string) (*api.Secret, error)
     WriteSecret(path string, data map[string]interface{}) (*api.Secret, error)
+    RevokeToken(token string) error
+    RevokeAccessor(accessor string) error
+    LookupSelf() (*api.Secret, error)
+    SealStatus() (*api.SealStatusResponse, error)
+    RenewalError() error
 }
@@ -4,43 +4,106 @@ import (
     "os"
     "fmt"
     "syscall"
-    "net/http"
+    "crypto/x509"
     
     flags "github.com/jessevdk/go-flags"
     log "github.com/Sirupsen/logrus"
     
-    "github.com/bluestatedigital/centralbooking/v1"
+    "github.com/bluestatedigital/centralbooking/config"
     "github.com/bluestatedigital/centralbooking/helpers"
-    "github.com/bluestatedigital/centralbooking/instance"
-    
-    consulapi "github.com/hashicorp/consul/api"
-    
-    "github.com/gorilla/mux"
 )
 
 var version string = "undef"
 
+// apply to every command
 type Options struct {
     Debug      bool   `env:"DEBUG"     long:"debug"    description:"enable debug"`
     LogFile    string `env:"LOG_FILE"  long:"log-file" description:"path to JSON log file"`
+}
+
+// what the server is configured with, besides Vault
+type ConfigOptions struct {
+    TrustedProxies []string `env:"TRUSTED_PROXIES" env-delim:"," long:"trusted-proxy" description:"CIDR of a proxy whose forwarding header is trusted; may be repeated"`
+    ForwardedHeader string `env:"FORWARDED_HEADER" long:"forwarded-header" description:"header trusted proxies record the client address in" choice:"xff" choice:"forwarded" default:"xff"`
+    ConfigFile string `env:"CONFIG_FILE" long:"config" description:"path to JSON config file" required:"true"`
+}
+
+// loads and checks the config file, trusted proxies and, unless
+// skipCertificates, the AWS identity certificates
+func (self *ConfigOptions) load(skipCertificates bool) (*helpers.TrustedProxies, *config.Config, []*x509.Certificate, error) {
+    trustedProxies, err := helpers.ParseTrustedProxies(self.TrustedProxies, self.ForwardedHeader)
+    if err != nil {
+        return nil, nil, nil, fmt.Errorf("parsing --trusted-proxy: %s", err)
+    }
+    
+    cfg, err := config.Load(self.ConfigFile)
+    if err != nil {
+        return nil, nil, nil, err
+    }
     
-    HttpPort   int    `env:"HTTP_PORT" long:"port"     description:"port to accept requests on" default:"8080"`
+    if skipCertificates {
+        return trustedProxies, cfg, nil, nil
+    }
     
-    VaultAddr  string `env:"VAULT_ADDR"  long:"vault-addr"  description:"address of the Vault server"     required:"true"`
-    VaultToken string `env:"VAULT_TOKEN" long:"vault-token" description:"auth token for this application" required:"true"`
+    awsCerts, err := helpers.LoadCertificates(cfg.AWS.IdentityCertificates)
+    if err != nil {
+        return nil, nil, nil, fmt.Errorf("loading AWS identity certificates: %s", err)
+    }
+    
+    return trustedProxies, cfg, awsCerts, nil
+}
+
+type command interface {
+    run() error
 }
 
-func Log(handler http.Handler) http.Handler {
-    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-        log.Infof("%s %s %s", r.RemoteAddr, r.Method, r.URL)
-        handler.ServeHTTP(w, r)
-    })
+// prints the version
+type VersionCommand struct {}
+
+func (self *VersionCommand) run() error {
+    fmt.Println(version)
+    return nil
 }
 
 func main() {
     var opts Options
     
-    _, err := flags.Parse(&opts)
+    parser := flags.NewParser(&opts, flags.Default)
+    
+    commands := map[string]command{}
+    addCommand := func(name, short, long string, cmd command) {
+        _, err := parser.AddCommand(name, short, long, cmd)
+        checkError(fmt.Sprintf("adding %s command", name), err)
+        
+        commands[name] = cmd
+    }
+    
+    addCommand(
+        "serve",
+        "run the registration service",
+        "Accepts instance registrations.",
+        &ServeCommand{},
+    )
+    addCommand(
+        "validate-config",
+        "check the config file",
+        "Loads and checks the config file, trusted proxies and AWS identity certificates, without contacting Vault or Consul.",
+        &ValidateConfigCommand{},
+    )
+    addCommand(
+        "bootstrap",
+        "register this instance",
+        "Registers the EC2 instance this runs on and writes out its credentials.",
+        &BootstrapCommand{},
+    )
+    addCommand(
+        "version",
+        "print the version",
+        "Prints the version.",
+        &VersionCommand{},
+    )
+    
+    _, err := parser.Parse()
     if err != nil {
         os.Exit(1)
     }
@@ -66,29 +129,5 @@ func main() {
         log.SetOutput(logFp)
     }
     
-    log.Debug("hi there! (tickertape tickertape)")
-    log.Infof("version: %s", version)
-    
-    vaultClient, err := helpers.NewVaultClient(opts.VaultAddr, opts.VaultToken)
-    checkError("creating Vault client", err)
-
-    consulClient, err := consulapi.NewClient(consulapi.DefaultConfig())    
-    checkError("creating Consul client", err)
-    
-    router := mux.NewRouter()
-    
-    registrar := instance.NewRegistrar(vaultClient)
-    v1 := v1.NewCentralBooking(
-        registrar,
-        consulClient.Catalog(),
-        vaultClient.GetEndpoint(),
-    )
-    v1.InstallHandlers(router.PathPrefix("/v1").Subrouter())
-    
-    httpServer := &http.Server{
-        Addr: fmt.Sprintf(":%d", opts.HttpPort),
-        Handler: Log(router),
-    }
-    
-    checkError("launching HTTP server", httpServer.ListenAndServe())
+    checkError(parser.Active.Name, commands[parser.Active.Name].run())
 }
@@ -0,0 +1,137 @@
+package main
+
+import (
+    "fmt"
+    "errors"
+    "context"
+    "net/http"
+    
+    log "github.com/Sirupsen/logrus"
+    
+    "github.com/bluestatedigital/centralbooking/v1"
+    "github.com/bluestatedigital/centralbooking/helpers"
+    "github.com/bluestatedigital/centralbooking/instance"
+    
+    consulapi "github.com/hashicorp/consul/api"
+    
+    "github.com/gorilla/mux"
+)
+
+// runs the registration service
+type ServeCommand struct {
+    ConfigOptions
+    
+    HttpPort   int    `env:"HTTP_PORT" long:"port"     description:"port to accept requests on" default:"8080"`
+    
+    VaultAddr  string `env:"VAULT_ADDR"  long:"vault-addr"  description:"address of the Vault server"     required:"true"`
+    VaultToken string `env:"VAULT_TOKEN" long:"vault-token" description:"auth token for this application"`
+    
+    VaultRoleIDFile   string `env:"VAULT_ROLE_ID_FILE"   long:"vault-role-id-file"   description:"file containing the AppRole role_id to log in with, instead of --vault-token"`
+    VaultSecretIDFile string `env:"VAULT_SECRET_ID_FILE" long:"vault-secret-id-file" description:"file containing the AppRole secret_id to log in with"`
+    VaultAppRolePath  string `env:"VAULT_APPROLE_PATH"   long:"vault-approle-path"   description:"mount path of the AppRole backend" default:"approle"`
+    
+    VaultRenewFraction float64 `env:"VAULT_RENEW_FRACTION" long:"vault-renew-fraction" description:"renew the Vault token after this fraction of its TTL" default:"0.5"`
+}
+
+func Log(handler http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        log.Infof("%s %s %s", r.RemoteAddr, r.Method, r.URL)
+        handler.ServeHTTP(w, r)
+    })
+}
+
+func (self *ServeCommand) run() error {
+    if self.VaultRenewFraction <= 0 || self.VaultRenewFraction >= 1 {
+        return errors.New("--vault-renew-fraction must be between 0 and 1")
+    }
+    
+    if (self.VaultToken == "") == (self.VaultRoleIDFile == "") {
+        return errors.New("exactly one of --vault-token and --vault-role-id-file is required")
+    }
+    
+    log.Debug("hi there! (tickertape tickertape)")
+    log.Infof("version: %s", version)
+    
+    trustedProxies, cfg, awsCerts, err := self.load(false)
+    checkError("loading config", err)
+    
+    var vaultClient *helpers.VaultClient
+    if self.VaultRoleIDFile != "" {
+        vaultClient, err = helpers.NewAppRoleVaultClient(
+            self.VaultAddr,
+            self.VaultAppRolePath,
+            self.VaultRoleIDFile,
+            self.VaultSecretIDFile,
+        )
+    } else {
+        vaultClient, err = helpers.NewVaultClient(self.VaultAddr, self.VaultToken)
+    }
+    checkError("creating Vault client", err)
+    
+    ec2Describer, err := helpers.NewEC2Describer(cfg.AWS.EC2Endpoint, cfg.AWS.STSEndpoint, cfg.AWS.AssumeRoles)
+    checkError("creating EC2 client", err)
+
+    consulClient, err := consulapi.NewClient(consulapi.DefaultConfig())    
+    checkError("creating Consul client", err)
+    
+    router := mux.NewRouter()
+    
+    registrar := instance.NewRegistrar(
+        vaultClient,
+        helpers.NewIdentityVerifier(awsCerts),
+        ec2Describer,
+        consulClient.KV(),
+        consulClient.ACL(),
+        cfg,
+    )
+    checkError("checking Vault token roles", registrar.CheckTokenRoles())
+    
+    v1 := v1.NewCentralBooking(
+        registrar,
+        consulClient.Catalog(),
+        consulClient.Agent(),
+        vaultClient.GetEndpoint(),
+        vaultClient,
+        trustedProxies,
+        cfg,
+    )
+    v1.InstallHandlers(router.PathPrefix("/v1").Subrouter())
+    
+    httpServer := &http.Server{
+        Addr: fmt.Sprintf(":%d", self.HttpPort),
+        Handler: Log(router),
+    }
+    
+    // keep our own token alive; if it can't be renewed, stop accepting
+    // registrations that are doomed to fail
+    renewErr := make(chan error, 1)
+    go func() {
+        renewErr <- vaultClient.RenewToken(self.VaultRenewFraction, nil)
+        
+        log.Error("Vault token can no longer be renewed; shutting down")
+        httpServer.Shutdown(context.Background())
+    }()
+    
+    stopReconciler := make(chan struct{})
+    if cfg.Reconciler.Interval.Duration > 0 {
+        reconciler := instance.NewReconciler(
+            vaultClient,
+            ec2Describer,
+            consulClient.KV(),
+            consulClient.ACL(),
+            cfg,
+        )
+        
+        go reconciler.Run(stopReconciler)
+    }
+    
+    err = httpServer.ListenAndServe()
+    close(stopReconciler)
+    if err != http.ErrServerClosed {
+        checkError("launching HTTP server", err)
+    }
+    
+    checkError("renewing Vault token", <-renewErr)
+    
+    return nil
+}
@@ -0,0 +1,37 @@
+package v1
+
+import (
+    "net/http"
+    
+    log "github.com/Sirupsen/logrus"
+)
+
+// wraps the handler so that it's only invoked for requests whose X-Vault-Token
+// carries the admin policy (or root)
+func (self *CentralBooking) requireAdmin(handler http.HandlerFunc) http.HandlerFunc {
+    return func(resp http.ResponseWriter, req *http.Request) {
+        token := req.Header.Get("X-Vault-Token")
+        if token == "" {
+            http.Error(resp, "missing X-Vault-Token", http.StatusUnauthorized)
+            return
+        }
+        
+        secret, err := self.vaultClient.WithToken(token).LookupSelf()
+        if err != nil || secret == nil {
+            log.Warnf("unable to look up admin token from %s: %v", self.trustedProxies.RemoteAddr(req), err)
+            http.Error(resp, "permission denied", http.StatusForbidden)
+            return
+        }
+        
+        policies, _ := secret.Data["policies"].([]interface{})
+        for _, policy := range policies {
+            if policy == "root" || policy == self.config.Vault.AdminPolicy {
+                handler(resp, req)
+                return
+            }
+        }
+        
+        log.Warnf("token from %s lacks the %s policy", self.trustedProxies.RemoteAddr(req), self.config.Vault.AdminPolicy)
+        http.Error(resp, "permission denied", http.StatusForbidden)
+    }
+}
@@ -3,31 +3,51 @@ package v1
 
 import (
     "fmt"
-    "net"
+    "sync"
+    "strings"
     "net/http"
     "io/ioutil"
     "encoding/json"
+    "encoding/base64"
     
     log "github.com/Sirupsen/logrus"
 
     "github.com/gorilla/mux"
     
+    "github.com/bluestatedigital/centralbooking/config"
+    "github.com/bluestatedigital/centralbooking/helpers"
     "github.com/bluestatedigital/centralbooking/instance"
     "github.com/bluestatedigital/centralbooking/interfaces"
 )
 
+// the identity document and signature are a few KB; anything much bigger
+// isn't a registration
+const maxRegisterBodySize = 64 * 1024
+
 type CentralBooking struct {
     registrar         *instance.Registrar
     consulCatalog     interfaces.ConsulCatalog
+    consulAgent       interfaces.ConsulAgent
     vaultEndpoint     string
+    vaultClient       interfaces.VaultClient
+    trustedProxies    *helpers.TrustedProxies
+    config            *config.Config
+    
+    // datacenter of the local Consul agent, once known
+    localDC           string
+    localDCLock       sync.Mutex
 }
 
 // returns a new CentralBooking instance
-func NewCentralBooking(registrar *instance.Registrar, catalog interfaces.ConsulCatalog, vaultEndpoint string) *CentralBooking {
+func NewCentralBooking(registrar *instance.Registrar, catalog interfaces.ConsulCatalog, agent interfaces.ConsulAgent, vaultEndpoint string, vaultClient interfaces.VaultClient, trustedProxies *helpers.TrustedProxies, cfg *config.Config) *CentralBooking {
     return &CentralBooking{
         registrar:         registrar,
         consulCatalog:     catalog,
+        consulAgent:       agent,
         vaultEndpoint:     vaultEndpoint,
+        vaultClient:       vaultClient,
+        trustedProxies:    trustedProxies,
+        config:            cfg,
     }
 }
 
@@ -37,6 +57,16 @@ func (self *CentralBooking) InstallHandlers(router *mux.Router) {
         Methods("POST").
         Path("/register/instance").
         HandlerFunc(self.RegisterInstance)
+    
+    router.
+        Methods("DELETE").
+        Path("/register/instance/{env}/{provider}/{account}/{region}/{instance_id}").
+        HandlerFunc(self.requireAdmin(self.DeregisterInstance))
+    
+    router.
+        Methods("POST").
+        Path("/register/instance/{env}/{provider}/{account}/{region}/{instance_id}/allow-reregistration").
+        HandlerFunc(self.requireAdmin(self.AllowReregistration))
 
     // apeing vault
     router.
@@ -48,17 +78,8 @@ func (self *CentralBooking) InstallHandlers(router *mux.Router) {
 // returns the index view
 func (self *CentralBooking) RegisterInstance(resp http.ResponseWriter, req *http.Request) {
     var err error
-    var remoteAddr string
     
-    if xff, ok := req.Header["X-Forwarded-For"]; ok {
-        remoteAddr = xff[0]
-    } else {
-        remoteAddr, _, err = net.SplitHostPort(req.RemoteAddr)
-        if err != nil {
-            log.Errorf("unable to parse RemoteAddr: %s", err)
-            remoteAddr = req.RemoteAddr
-        }
-    }
+    remoteAddr := self.trustedProxies.RemoteAddr(req)
     
     logEntry := log.WithField("remote_ip", remoteAddr)
     
@@ -70,11 +91,19 @@ func (self *CentralBooking) RegisterInstance(resp http.ResponseWriter, req *http
         Instance_ID string
         Role        string
         Policies    []string
+        
+        // the instance identity document and its base64-encoded PKCS7
+        // signature, as retrieved from the EC2 metadata service
+        Identity_Document  string
+        Identity_Signature string
+        
+        // optional; signed if the role has an SSH CA
+        SSH_Host_Public_Key string
     }
     
     var payload payloadType
 
-    body, err := ioutil.ReadAll(req.Body)
+    body, err := ioutil.ReadAll(http.MaxBytesReader(resp, req.Body, maxRegisterBodySize))
     if err != nil {
         log.Errorf("unable to read body: %s", err)
         http.Error(resp, "unable to read body", http.StatusBadRequest)
@@ -88,6 +117,35 @@ func (self *CentralBooking) RegisterInstance(resp http.ResponseWriter, req *http
         return
     }
     
+    // the metadata service wraps the signature across multiple lines
+    signature, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(payload.Identity_Signature), ""))
+    if err != nil {
+        log.Errorf("unable to decode identity signature: %s", err)
+        http.Error(resp, "unable to decode identity signature", http.StatusBadRequest)
+        return
+    }
+    
+    // resolved up front, along with the Consul servers, so we don't hand out
+    // tokens for a response we can't complete
+    datacenter, aclDatacenter, err := self.datacenters(payload.Environment)
+    if err != nil {
+        log.Errorf("unable to determine Consul datacenter: %s", err)
+        http.Error(resp, "unable to determine Consul datacenter", http.StatusInternalServerError)
+        return
+    }
+    
+    svcs, _, err := self.consulCatalog.Service("consul-wan", "", nil)
+    if err != nil {
+        log.Errorf("unable to retrieve consul-wan service: %s", err)
+        http.Error(resp, "unable to retrieve consul-wan service", http.StatusInternalServerError)
+        return
+    }
+    
+    consulServers := make([]string, 0, len(svcs))
+    for _, svc := range svcs {
+        consulServers = append(consulServers, fmt.Sprintf("%s:%d", svc.ServiceAddress, svc.ServicePort))
+    }
+    
     logEntry.Info("registering instance")
     regResp, err := self.registrar.Register(&instance.RegisterRequest{
         Env:        payload.Environment,
@@ -97,36 +155,38 @@ func (self *CentralBooking) RegisterInstance(resp http.ResponseWriter, req *http
         InstanceID: payload.Instance_ID,
         Role:       payload.Role,
         Policies:   payload.Policies,
+        
+        IdentityDocument:  []byte(payload.Identity_Document),
+        IdentitySignature: signature,
+        
+        RemoteAddr: remoteAddr,
+        
+        SSHHostPublicKey: payload.SSH_Host_Public_Key,
     })
     
     if err != nil {
-        sc := http.StatusInternalServerError
-        
-        if _, ok := err.(*instance.ValidationError); ok {
-            sc = http.StatusBadRequest
-        }
-        
-        http.Error(resp, err.Error(), sc)
+        http.Error(resp, err.Error(), errorStatus(err))
         return
     }
 
-    svcs, _, err := self.consulCatalog.Service("consul-wan", "", nil)
-    if err != nil {
-        log.Errorf("unable to retrieve consul-wan service: %s", err)
-        http.Error(resp, "unable to retrieve consul-wan service", http.StatusInternalServerError)
-        return
+    respBody := map[string]interface{}{
+        "temp_token":        regResp.TempToken,
+        "registration_mode": regResp.RegistrationMode,
+        "vault_endpoint":    self.vaultEndpoint,
+        "consul_servers":    consulServers,
+        "datacenter":        datacenter,
     }
-
-    consulServers := make([]string, 0, len(svcs))
-    for _, svc := range svcs {
-        consulServers = append(consulServers, fmt.Sprintf("%s:%d", svc.ServiceAddress, svc.ServicePort))
+    
+    // ACLs may not be in use
+    if aclDatacenter != "" {
+        respBody["acl_datacenter"] = aclDatacenter
+    }
+    
+    if regResp.SSHHostCertificate != "" {
+        respBody["ssh_host_certificate"] = regResp.SSHHostCertificate
     }
 
-    respBytes, err := json.Marshal(map[string]interface{}{
-        "temp_token":     regResp.TempToken,
-        "vault_endpoint": self.vaultEndpoint,
-        "consul_servers": consulServers,
-    })
+    respBytes, err := json.Marshal(respBody)
     if err != nil {
         log.Errorf("unable to marshal response body: %s", err)
         http.Error(resp, "failed generating response body", http.StatusInternalServerError)
@@ -138,9 +198,59 @@ func (self *CentralBooking) RegisterInstance(resp http.ResponseWriter, req *http
     resp.Write(respBytes)
 }
 
-func (self *CentralBooking) CheckHealth(resp http.ResponseWriter, req *http.Request) {
-    resp.WriteHeader(http.StatusOK)
+// revokes an instance's tokens and removes its record
+func (self *CentralBooking) DeregisterInstance(resp http.ResponseWriter, req *http.Request) {
+    vars := mux.Vars(req)
+    
+    err := self.registrar.Deregister(&instance.InstanceRequest{
+        Env:        vars["env"],
+        Provider:   vars["provider"],
+        Account:    vars["account"],
+        Region:     vars["region"],
+        InstanceID: vars["instance_id"],
+    })
+    
+    if err != nil {
+        http.Error(resp, err.Error(), errorStatus(err))
+        return
+    }
+    
+    resp.WriteHeader(http.StatusNoContent)
+}
 
-    // http://labs.omniti.com/labs/jsend
-    resp.Write([]byte(`{"status":"success"}`))
+// lets an instance register again
+func (self *CentralBooking) AllowReregistration(resp http.ResponseWriter, req *http.Request) {
+    vars := mux.Vars(req)
+    
+    err := self.registrar.AllowReregistration(&instance.InstanceRequest{
+        Env:        vars["env"],
+        Provider:   vars["provider"],
+        Account:    vars["account"],
+        Region:     vars["region"],
+        InstanceID: vars["instance_id"],
+    })
+    
+    if err != nil {
+        http.Error(resp, err.Error(), errorStatus(err))
+        return
+    }
+    
+    resp.WriteHeader(http.StatusNoContent)
+}
+
+// maps errors from the registrar to response codes
+func errorStatus(err error) int {
+    switch err.(type) {
+        case *instance.ValidationError:
+            return http.StatusBadRequest
+        
+        case *instance.NotFoundError:
+            return http.StatusNotFound
+        
+        case *instance.ConflictError:
+            return http.StatusConflict
+        
+        default:
+            return http.StatusInternalServerError
+    }
 }
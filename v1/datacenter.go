@@ -0,0 +1,47 @@
+package v1
+
+import (
+    "errors"
+)
+
+// returns the datacenter and ACL datacenter for instances in the environment
+func (self *CentralBooking) datacenters(env string) (string, string, error) {
+    envConfig, _ := self.config.Environment(env)
+    
+    dc := envConfig.Datacenter
+    if dc == "" {
+        var err error
+        
+        dc, err = self.localDatacenter()
+        if err != nil {
+            return "", "", err
+        }
+    }
+    
+    return dc, envConfig.ACLDatacenter, nil
+}
+
+// returns the datacenter of the local Consul agent.  it can't change without
+// restarting the agent, so it's only looked up once.
+func (self *CentralBooking) localDatacenter() (string, error) {
+    self.localDCLock.Lock()
+    defer self.localDCLock.Unlock()
+    
+    if self.localDC != "" {
+        return self.localDC, nil
+    }
+    
+    agentSelf, err := self.consulAgent.Self()
+    if err != nil {
+        return "", err
+    }
+    
+    dc, ok := agentSelf["Config"]["Datacenter"].(string)
+    if !ok || dc == "" {
+        return "", errors.New("no datacenter in agent config")
+    }
+    
+    self.localDC = dc
+    
+    return dc, nil
+}
@@ -0,0 +1,141 @@
+package v1
+
+import (
+    "net/http"
+    "encoding/json"
+    
+    log "github.com/Sirupsen/logrus"
+)
+
+// JSend statuses
+// http://labs.omniti.com/labs/jsend
+const (
+    jsendSuccess = "success"
+    jsendFail    = "fail"
+    jsendError   = "error"
+)
+
+// health of a single dependency, as a JSend object
+type dependencyHealth struct {
+    Status  string                 `json:"status"`
+    Message string                 `json:"message,omitempty"`
+    Data    map[string]interface{} `json:"data,omitempty"`
+}
+
+// verifies our Vault token is usable and still being renewed, and Vault is
+// unsealed
+func (self *CentralBooking) checkVault() (*dependencyHealth, int) {
+    sealStatus, err := self.vaultClient.SealStatus()
+    if err != nil {
+        log.Errorf("unable to retrieve Vault seal status: %s", err)
+        return &dependencyHealth{
+            Status:  jsendError,
+            Message: "unable to retrieve seal status",
+        }, http.StatusServiceUnavailable
+    }
+    
+    if sealStatus.Sealed {
+        return &dependencyHealth{
+            Status: jsendFail,
+            Data:   map[string]interface{}{ "sealed": true },
+        }, http.StatusServiceUnavailable
+    }
+    
+    _, err = self.vaultClient.LookupSelf()
+    if err != nil {
+        log.Errorf("unable to look up Vault token: %s", err)
+        return &dependencyHealth{
+            Status:  jsendError,
+            Message: "unable to look up token",
+        }, http.StatusServiceUnavailable
+    }
+    
+    // the token works for now, but will expire unless renewal recovers
+    renewErr := self.vaultClient.RenewalError()
+    if renewErr != nil {
+        return &dependencyHealth{
+            Status: jsendFail,
+            Data:   map[string]interface{}{
+                "sealed":        false,
+                "renewal_error": renewErr.Error(),
+            },
+        }, http.StatusTooManyRequests
+    }
+    
+    return &dependencyHealth{
+        Status: jsendSuccess,
+        Data:   map[string]interface{}{ "sealed": false },
+    }, http.StatusOK
+}
+
+// verifies the consul-wan service can be retrieved from Consul
+func (self *CentralBooking) checkConsul() (*dependencyHealth, int) {
+    svcs, _, err := self.consulCatalog.Service("consul-wan", "", nil)
+    if err != nil {
+        log.Errorf("unable to retrieve consul-wan service: %s", err)
+        return &dependencyHealth{
+            Status:  jsendError,
+            Message: "unable to retrieve consul-wan service",
+        }, http.StatusServiceUnavailable
+    }
+    
+    data := map[string]interface{}{ "consul_servers": len(svcs) }
+    
+    if len(svcs) == 0 {
+        // registrations will succeed, but instances won't be able to join
+        return &dependencyHealth{
+            Status: jsendFail,
+            Data:   data,
+        }, http.StatusTooManyRequests
+    }
+    
+    return &dependencyHealth{
+        Status: jsendSuccess,
+        Data:   data,
+    }, http.StatusOK
+}
+
+// reports the health of our dependencies.  status codes follow Vault's
+// /sys/health: 200 if healthy, 429 if degraded, 503 if unable to register
+// instances.
+func (self *CentralBooking) CheckHealth(resp http.ResponseWriter, req *http.Request) {
+    deps := map[string]*dependencyHealth{}
+    sc := http.StatusOK
+    
+    var code int
+    deps["vault"], code = self.checkVault()
+    if code > sc {
+        sc = code
+    }
+    
+    deps["consul"], code = self.checkConsul()
+    if code > sc {
+        sc = code
+    }
+    
+    body := map[string]interface{}{
+        "status": jsendSuccess,
+        "data":   deps,
+    }
+    
+    for _, dep := range deps {
+        if dep.Status == jsendError {
+            body["status"] = jsendError
+            body["message"] = "dependency check failed"
+            break
+        } else if dep.Status == jsendFail {
+            body["status"] = jsendFail
+        }
+    }
+    
+    respBytes, err := json.Marshal(body)
+    if err != nil {
+        log.Errorf("unable to marshal response body: %s", err)
+        http.Error(resp, "failed generating response body", http.StatusInternalServerError)
+        return
+    }
+    
+    resp.Header().Add("Content-Type", "application/json")
+    resp.WriteHeader(sc)
+    resp.Write(respBytes)
+}
@@ -1,18 +1,24 @@
 package v1_test
 
 import (
+    "github.com/bluestatedigital/centralbooking/config"
     "github.com/bluestatedigital/centralbooking/interfaces"
     "github.com/bluestatedigital/centralbooking/v1"
     "github.com/bluestatedigital/centralbooking/instance"
     
     vaultapi "github.com/hashicorp/vault/api"
     consulapi "github.com/hashicorp/consul/api"
+    "github.com/aws/aws-sdk-go/aws"
+    "github.com/aws/aws-sdk-go/aws/ec2metadata"
+    "github.com/aws/aws-sdk-go/service/ec2"
 
     . "github.com/onsi/ginkgo"
     . "github.com/onsi/gomega"
     
     "github.com/stretchr/testify/mock"
     
+    "fmt"
+    "time"
     "strings"
     "io/ioutil"
     "encoding/json"
@@ -29,6 +35,12 @@ var _ = Describe("CentralBooking v1", func() {
     var mockVaultClient interfaces.MockVaultClient
     var mockConsulCatalog interfaces.MockConsulCatalog
     var mockVaultClientTemp interfaces.MockVaultClient
+    var mockIdentityVerifier interfaces.MockIdentityVerifier
+    var mockEC2Describer interfaces.MockEC2Describer
+    var mockConsulKV interfaces.MockConsulKV
+    var mockConsulACL interfaces.MockConsulACL
+    var mockConsulAgent interfaces.MockConsulAgent
+    var cfg *config.Config
     
     BeforeEach(func() {
         router = mux.NewRouter()
@@ -39,11 +51,55 @@ var _ = Describe("CentralBooking v1", func() {
         mockConsulCatalog = interfaces.MockConsulCatalog{}
 
         mockVaultClientTemp = interfaces.MockVaultClient{}
+        mockIdentityVerifier = interfaces.MockIdentityVerifier{}
+        mockEC2Describer = interfaces.MockEC2Describer{}
+        mockConsulKV = interfaces.MockConsulKV{}
+        mockConsulACL = interfaces.MockConsulACL{}
+        mockConsulAgent = interfaces.MockConsulAgent{}
+
+        cfg = &config.Config{
+            AWS: config.AWSConfig{
+                Accounts: map[string]string{
+                    "gen": "123456789012",
+                },
+                MaxLaunchAge: config.Duration{ Duration: 10 * time.Minute },
+            },
+            Vault: config.VaultConfig{
+                AdminPolicy: "centralbooking-admin",
+            },
+            Environments: map[string]config.EnvironmentConfig{
+                "dev": config.EnvironmentConfig{
+                    ACLDatacenter: "us-east-1",
+                },
+            },
+            Roles: []config.RoleConfig{
+                config.RoleConfig{
+                    Environment: "dev",
+                    Provider:    "aws",
+                    Role:        "cluster-server",
+                    Policies:    []string{ "instance-management" },
+                },
+            },
+        }
+
+        // fills in defaults
+        Expect(cfg.Validate()).To(BeNil())
 
         cb = v1.NewCentralBooking(
-            instance.NewRegistrar(&mockVaultClient),
+            instance.NewRegistrar(
+                &mockVaultClient,
+                &mockIdentityVerifier,
+                &mockEC2Describer,
+                &mockConsulKV,
+                &mockConsulACL,
+                cfg,
+            ),
             &mockConsulCatalog,
+            &mockConsulAgent,
             "https://vault.example.com/",
+            &mockVaultClient,
+            nil,
+            cfg,
         )
         cb.InstallHandlers(router.PathPrefix("/v1").Subrouter())
     })
@@ -51,6 +107,39 @@ var _ = Describe("CentralBooking v1", func() {
     Describe("instance registration", func() {
         endpoint := "http://example.com/v1/register/instance"
         
+        BeforeEach(func() {
+            // not registered yet
+            mockConsulKV.
+                On("Get", mock.AnythingOfType("string"), mock.AnythingOfType("*api.QueryOptions")).
+                Return(nil, nil, nil)
+            
+            mockConsulAgent.
+                On("Self").
+                Return(map[string]map[string]interface{}{
+                    "Config": map[string]interface{}{
+                        "Datacenter": "us-east-1a",
+                    },
+                }, nil)
+            
+            mockConsulCatalog.
+                On("Service", "consul-wan", "", mock.AnythingOfType("*api.QueryOptions")).
+                Return(
+                    []*consulapi.CatalogService{
+                        &consulapi.CatalogService{
+                            Node:                     "cluster-server-f022e6e6",
+                            Address:                  "10.112.16.35",
+                            ServiceID:                "consul-wan",
+                            ServiceName:              "consul-wan",
+                            ServiceAddress:           "127.0.0.2",
+                            ServicePort:              8302,
+                            ServiceEnableTagOverride: false,
+                        },
+                    },
+                    nil,
+                    nil,
+                )
+        })
+        
         It("should fail with invalid GET verb", func() {
             req, err := http.NewRequest("GET", endpoint, nil)
             Expect(err).To(BeNil())
@@ -59,7 +148,7 @@ var _ = Describe("CentralBooking v1", func() {
             Expect(resp.Code).To(Equal(404))
         })
         
-        It("should fail if policies not provided", func() {
+        It("should fail if no policies are allowed for the role", func() {
             req, err := http.NewRequest(
                 "POST", endpoint,
                 strings.NewReader(`{
@@ -68,7 +157,7 @@ var _ = Describe("CentralBooking v1", func() {
                     "account":     "gen",
                     "region":      "us-east-1",
                     "instance_id": "i-04c9c4c4",
-                    "role":        "cluster-server"
+                    "role":        "web-server"
                 }`),
             )
             Expect(err).To(BeNil())
@@ -103,10 +192,102 @@ var _ = Describe("CentralBooking v1", func() {
             mockConsulCatalog.AssertExpectations(GinkgoT())
             mockVaultClientTemp.AssertExpectations(GinkgoT())
         })
+        
+        It("should fail if the body is too large", func() {
+            req, err := http.NewRequest(
+                "POST", endpoint,
+                strings.NewReader(`{"identity_signature": "` + strings.Repeat("MIAGCSqG", 1 << 20) + `"}`),
+            )
+            Expect(err).To(BeNil())
+
+            router.ServeHTTP(resp, req)
+            Expect(resp.Code).To(Equal(400))
+            
+            mockIdentityVerifier.AssertNotCalled(GinkgoT(), "Verify", mock.Anything, mock.Anything)
+        })
+        
+        It("should fail if the identity signature is not base64", func() {
+            req, err := http.NewRequest(
+                "POST", endpoint,
+                strings.NewReader(`{
+                    "environment":        "dev",
+                    "provider":           "aws",
+                    "account":            "gen",
+                    "region":             "us-east-1",
+                    "instance_id":        "i-04c9c4c4",
+                    "role":               "cluster-server",
+                    "policies":           [ "instance-management" ],
+                    "identity_document":  "{}",
+                    "identity_signature": "not base64!"
+                }`),
+            )
+            Expect(err).To(BeNil())
+
+            router.ServeHTTP(resp, req)
+            Expect(resp.Code).To(Equal(400))
+            
+            mockIdentityVerifier.AssertExpectations(GinkgoT())
+            mockVaultClient.AssertExpectations(GinkgoT())
+        })
+
+        It("should fail if the ssh host public key is invalid", func() {
+            req, err := http.NewRequest(
+                "POST", endpoint,
+                strings.NewReader(`{
+                    "environment":         "dev",
+                    "provider":            "aws",
+                    "account":             "gen",
+                    "region":              "us-east-1",
+                    "instance_id":         "i-04c9c4c4",
+                    "role":                "cluster-server",
+                    "ssh_host_public_key": "not a key"
+                }`),
+            )
+            Expect(err).To(BeNil())
+
+            router.ServeHTTP(resp, req)
+            Expect(resp.Code).To(Equal(400))
+            
+            mockIdentityVerifier.AssertNotCalled(GinkgoT(), "Verify", mock.Anything, mock.Anything)
+        })
 
         Describe("in aws", func() {
             It("processes request successfully", func() {
-                // @todo retrieves instance detail from aws
+                // verifies the identity document; the signature is decoded
+                // from base64, including line breaks
+                mockIdentityVerifier.
+                    On("Verify", []byte(`{"instanceId":"i-04c9c4c4"}`), []byte("pkcs7 signature")).
+                    Return(&ec2metadata.EC2InstanceIdentityDocument{
+                        InstanceID: "i-04c9c4c4",
+                        AccountID:  "123456789012",
+                        Region:     "us-east-1",
+                    }, nil).
+                    Once()
+                
+                // retrieves instance detail from aws
+                mockEC2Describer.
+                    On("DescribeInstances", "123456789012", "us-east-1", &ec2.DescribeInstancesInput{
+                        InstanceIds: []*string{ aws.String("i-04c9c4c4") },
+                    }).
+                    Return(
+                        &ec2.DescribeInstancesOutput{
+                            Reservations: []*ec2.Reservation{
+                                &ec2.Reservation{
+                                    Instances: []*ec2.Instance{
+                                        &ec2.Instance{
+                                            InstanceId:       aws.String("i-04c9c4c4"),
+                                            State:            &ec2.InstanceState{ Name: aws.String("running") },
+                                            LaunchTime:       aws.Time(time.Now().Add(-time.Minute)),
+                                            PrivateIpAddress: aws.String("10.112.16.35"),
+                                        },
+                                    },
+                                },
+                            },
+                        },
+                        nil,
+                    ).
+                    Once()
+                
                 // @todo retrieves coord cluster consul server addresses from *somewhere*
 
                 // generates perm vault token
@@ -122,7 +303,7 @@ var _ = Describe("CentralBooking v1", func() {
                             "instance_id": "i-04c9c4c4",
                             "role":        "cluster-server",
                         },
-                        Period: "72h",
+                        Period: "72h0m0s",
                         NoParent: true,
                     }).
                     Return(
@@ -156,7 +337,7 @@ var _ = Describe("CentralBooking v1", func() {
                 mockVaultClient.
                     On("CreateToken", &vaultapi.TokenCreateRequest{
                         DisplayName: "temp instance dev/aws/gen/us-east-1/i-04c9c4c4",
-                        // Policies: []string{ "cubbyhole-read" }, @todo no root policy!
+                        Policies: []string{ "default" },
                         Metadata: map[string]string{
                             "environment": "dev",
                             "provider":    "aws",
@@ -198,24 +379,11 @@ var _ = Describe("CentralBooking v1", func() {
                     Return(nil, nil).
                     Once()
                 
-                mockConsulCatalog.
-                    On("Service", "consul-wan", "", mock.AnythingOfType("*api.QueryOptions")).
-                    Return(
-                        []*consulapi.CatalogService{
-                            &consulapi.CatalogService{
-                                Node:                     "cluster-server-f022e6e6",
-                                Address:                  "10.112.16.35",
-                                ServiceID:                "consul-wan",
-                                ServiceName:              "consul-wan",
-                                ServiceAddress:           "127.0.0.2",
-                                ServicePort:              8302,
-                                ServiceEnableTagOverride: false,
-                            },
-                        },
-                        nil,
-                        nil,
-                    )
-
+                mockConsulKV.
+                    On("CAS", mock.AnythingOfType("*api.KVPair"), mock.AnythingOfType("*api.WriteOptions")).
+                    Return(true, nil, nil).
+                    Once()
+                
                 // returns payload with temp token, consul server addresses, vault endpoint
 
                 req, err := http.NewRequest(
@@ -228,16 +396,24 @@ var _ = Describe("CentralBooking v1", func() {
                         "instance_id": "i-04c9c4c4",
                         "role":        "cluster-server",
                         
-                        "policies":    ["instance-management"]
+                        "policies":    ["instance-management"],
+                        
+                        "identity_document":  "{\"instanceId\":\"i-04c9c4c4\"}",
+                        "identity_signature": "cGtjczcgc2ln\nbmF0dXJl"
                     }`),
                 )
                 Expect(err).To(BeNil())
+                
+                req.RemoteAddr = "10.112.16.35:41234"
 
                 router.ServeHTTP(resp, req)
                 Expect(resp.Code).To(Equal(200))
                 
+                mockIdentityVerifier.AssertExpectations(GinkgoT())
+                mockEC2Describer.AssertExpectations(GinkgoT())
                 mockVaultClient.AssertExpectations(GinkgoT())
                 mockConsulCatalog.AssertExpectations(GinkgoT())
+                mockConsulKV.AssertExpectations(GinkgoT())
                 mockVaultClientTemp.AssertExpectations(GinkgoT())
                 
                 var respPayload map[string]interface{}
@@ -245,9 +421,19 @@ var _ = Describe("CentralBooking v1", func() {
                 Expect(json.Unmarshal(respBytes, &respPayload)).To(BeNil())
                 
                 Expect(respPayload["temp_token"]).To(Equal("generated-temp-token"), "temp token")
+                Expect(respPayload["registration_mode"]).To(Equal("cubbyhole"), "registration mode")
+                Expect(respPayload["datacenter"]).To(Equal("us-east-1a"), "datacenter")
+                Expect(respPayload["acl_datacenter"]).To(Equal("us-east-1"), "acl datacenter")
                 Expect(respPayload["vault_endpoint"]).To(Equal("https://vault.example.com/"), "vault endpoint")
                 Expect(respPayload["consul_servers"]).To(ContainElement("127.0.0.2:8302"), "missing consul servers")
 
+                // the resolved remote address is passed through to the registrar
+                for _, call := range mockConsulKV.Calls {
+                    if call.Method == "CAS" {
+                        Expect(string(call.Arguments.Get(0).(*consulapi.KVPair).Value)).To(ContainSubstring(`"remote_addr":"10.112.16.35"`))
+                    }
+                }
+
                 // validate the payload of the cubbyhole/perm secret
                 writePermSecretCall := mockVaultClientTemp.Calls[0]
                 Expect(writePermSecretCall.Method).To(Equal("WriteSecret"))
@@ -263,15 +449,329 @@ var _ = Describe("CentralBooking v1", func() {
         })
     })
 
+    Describe("instance deregistration", func() {
+        endpoint := "http://example.com/v1/register/instance/dev/aws/gen/us-east-1/i-04c9c4c4"
+        recordKey := "centralbooking/instances/aws/123456789012/i-04c9c4c4"
+        
+        var mockVaultClientAdmin interfaces.MockVaultClient
+        
+        BeforeEach(func() {
+            mockVaultClientAdmin = interfaces.MockVaultClient{}
+        })
+        
+        deregister := func(token string) {
+            req, err := http.NewRequest("DELETE", endpoint, nil)
+            Expect(err).To(BeNil())
+            
+            if token != "" {
+                req.Header.Set("X-Vault-Token", token)
+            }
+
+            router.ServeHTTP(resp, req)
+        }
+        
+        adminToken := func(policies ...interface{}) {
+            mockVaultClient.On("WithToken", "admin-token").Return(&mockVaultClientAdmin)
+            mockVaultClientAdmin.
+                On("LookupSelf").
+                Return(&vaultapi.Secret{
+                    Data: map[string]interface{}{
+                        "policies": policies,
+                    },
+                }, nil)
+        }
+        
+        It("requires a token", func() {
+            deregister("")
+            Expect(resp.Code).To(Equal(401))
+            
+            mockConsulKV.AssertNotCalled(GinkgoT(), "Get", mock.Anything, mock.Anything)
+        })
+        
+        It("rejects invalid tokens", func() {
+            mockVaultClient.On("WithToken", "admin-token").Return(&mockVaultClientAdmin)
+            mockVaultClientAdmin.On("LookupSelf").Return(nil, fmt.Errorf("permission denied"))
+            
+            deregister("admin-token")
+            Expect(resp.Code).To(Equal(403))
+            
+            mockConsulKV.AssertNotCalled(GinkgoT(), "Get", mock.Anything, mock.Anything)
+        })
+        
+        It("rejects tokens without the admin policy", func() {
+            adminToken("default", "instance-management")
+            
+            deregister("admin-token")
+            Expect(resp.Code).To(Equal(403))
+            
+            mockConsulKV.AssertNotCalled(GinkgoT(), "Get", mock.Anything, mock.Anything)
+        })
+        
+        It("returns 404 for unregistered instances", func() {
+            adminToken("default", "centralbooking-admin")
+            mockConsulKV.
+                On("Get", recordKey, mock.AnythingOfType("*api.QueryOptions")).
+                Return(nil, nil, nil)
+            
+            deregister("admin-token")
+            Expect(resp.Code).To(Equal(404))
+        })
+        
+        It("revokes the perm token", func() {
+            adminToken("default", "centralbooking-admin")
+            mockConsulKV.
+                On("Get", recordKey, mock.AnythingOfType("*api.QueryOptions")).
+                Return(&consulapi.KVPair{
+                    Key:   recordKey,
+                    Value: []byte(`{"environment":"dev","provider":"aws","account":"gen","account_id":"123456789012","region":"us-east-1","instance_id":"i-04c9c4c4","accessor":"perm-accessor"}`),
+                }, nil, nil)
+            mockVaultClient.On("RevokeAccessor", "perm-accessor").Return(nil).Once()
+            mockConsulKV.
+                On("Delete", recordKey, mock.AnythingOfType("*api.WriteOptions")).
+                Return(nil, nil).
+                Once()
+            
+            deregister("admin-token")
+            Expect(resp.Code).To(Equal(204))
+            
+            mockVaultClient.AssertExpectations(GinkgoT())
+            mockConsulKV.AssertExpectations(GinkgoT())
+        })
+        
+        It("reports a conflict if the record changes while allowing re-registration", func() {
+            adminToken("centralbooking-admin")
+            mockConsulKV.
+                On("Get", recordKey, mock.AnythingOfType("*api.QueryOptions")).
+                Return(&consulapi.KVPair{
+                    Key:         recordKey,
+                    Value:       []byte(`{"environment":"dev","provider":"aws","account":"gen","account_id":"123456789012","region":"us-east-1","instance_id":"i-04c9c4c4","accessor":"perm-accessor"}`),
+                    ModifyIndex: 42,
+                }, nil, nil)
+            mockConsulKV.
+                On("CAS", mock.AnythingOfType("*api.KVPair"), mock.AnythingOfType("*api.WriteOptions")).
+                Return(false, nil, nil).
+                Once()
+            
+            req, err := http.NewRequest("POST", endpoint + "/allow-reregistration", nil)
+            Expect(err).To(BeNil())
+            req.Header.Set("X-Vault-Token", "admin-token")
+            
+            router.ServeHTTP(resp, req)
+            
+            // the record changed underneath us
+            Expect(resp.Code).To(Equal(409))
+            
+            mockConsulKV.AssertExpectations(GinkgoT())
+        })
+    })
+    
+    Describe("datacenter", func() {
+        endpoint := "http://example.com/v1/register/instance"
+        
+        payload := `{
+            "environment": "dev",
+            "provider":    "aws",
+            "account":     "gen",
+            "region":      "us-east-1",
+            "instance_id": "i-04c9c4c4",
+            "role":        "cluster-server"
+        }`
+        
+        It("should fail before registering if the local datacenter is unknown", func() {
+            mockConsulAgent.On("Self").Return(nil, fmt.Errorf("connection refused"))
+            
+            req, err := http.NewRequest("POST", endpoint, strings.NewReader(payload))
+            Expect(err).To(BeNil())
+
+            router.ServeHTTP(resp, req)
+            Expect(resp.Code).To(Equal(500))
+            
+            mockConsulAgent.AssertExpectations(GinkgoT())
+            mockIdentityVerifier.AssertNotCalled(GinkgoT(), "Verify", mock.Anything, mock.Anything)
+        })
+        
+        It("should fail before registering if the consul servers are unavailable", func() {
+            cfg.Environments["dev"] = config.EnvironmentConfig{
+                Datacenter: "us-west-2",
+            }
+            
+            mockConsulCatalog.
+                On("Service", "consul-wan", "", mock.AnythingOfType("*api.QueryOptions")).
+                Return(nil, nil, fmt.Errorf("connection refused"))
+            
+            req, err := http.NewRequest("POST", endpoint, strings.NewReader(payload))
+            Expect(err).To(BeNil())
+
+            router.ServeHTTP(resp, req)
+            Expect(resp.Code).To(Equal(500))
+            
+            mockConsulCatalog.AssertExpectations(GinkgoT())
+            mockIdentityVerifier.AssertNotCalled(GinkgoT(), "Verify", mock.Anything, mock.Anything)
+        })
+        
+        It("should not consult the agent if the datacenter is configured", func() {
+            cfg.Environments["dev"] = config.EnvironmentConfig{
+                Datacenter: "us-west-2",
+            }
+            
+            mockConsulCatalog.
+                On("Service", "consul-wan", "", mock.AnythingOfType("*api.QueryOptions")).
+                Return([]*consulapi.CatalogService{}, nil, nil)
+            
+            // fails validation, but only after the datacenter is resolved
+            req, err := http.NewRequest("POST", endpoint, strings.NewReader(`{
+                "environment": "dev",
+                "provider":    "aws",
+                "account":     "gen",
+                "region":      "us-east-1",
+                "instance_id": "i-04c9c4c4",
+                "role":        "web-server"
+            }`))
+            Expect(err).To(BeNil())
+
+            router.ServeHTTP(resp, req)
+            Expect(resp.Code).To(Equal(400))
+            
+            mockConsulAgent.AssertNotCalled(GinkgoT(), "Self")
+        })
+    })
+
     Describe("health check", func() {
         endpoint := "http://example.com/v1/sys/health"
-
-        It("should pass", func() {
+        
+        var healthBody map[string]interface{}
+        
+        checkHealth := func() {
             req, err := http.NewRequest("GET", endpoint, nil)
             Expect(err).To(BeNil())
 
             router.ServeHTTP(resp, req)
+            
+            Expect(resp.Header().Get("Content-Type")).To(Equal("application/json"))
+            
+            healthBody = map[string]interface{}{}
+            Expect(json.Unmarshal(resp.Body.Bytes(), &healthBody)).To(BeNil())
+        }
+        
+        depStatus := func(dep string) string {
+            return healthBody["data"].(map[string]interface{})[dep].(map[string]interface{})["status"].(string)
+        }
+        
+        consulServers := []*consulapi.CatalogService{
+            &consulapi.CatalogService{
+                Address:     "10.0.0.1",
+                ServicePort: 8302,
+            },
+        }
+        
+        AfterEach(func() {
+            mockVaultClient.AssertExpectations(GinkgoT())
+            mockConsulCatalog.AssertExpectations(GinkgoT())
+        })
+
+        It("should pass", func() {
+            mockVaultClient.On("SealStatus").Return(&vaultapi.SealStatusResponse{ Sealed: false }, nil)
+            mockVaultClient.On("LookupSelf").Return(&vaultapi.Secret{}, nil)
+            mockVaultClient.On("RenewalError").Return(nil)
+            mockConsulCatalog.
+                On("Service", "consul-wan", "", mock.AnythingOfType("*api.QueryOptions")).
+                Return(consulServers, nil, nil)
+            
+            checkHealth()
+            
             Expect(resp.Code).To(Equal(200))
+            Expect(healthBody["status"]).To(Equal("success"))
+            Expect(depStatus("vault")).To(Equal("success"))
+            Expect(depStatus("consul")).To(Equal("success"))
+        })
+        
+        It("should fail when Vault is sealed", func() {
+            mockVaultClient.On("SealStatus").Return(&vaultapi.SealStatusResponse{ Sealed: true }, nil)
+            mockConsulCatalog.
+                On("Service", "consul-wan", "", mock.AnythingOfType("*api.QueryOptions")).
+                Return(consulServers, nil, nil)
+            
+            checkHealth()
+            
+            Expect(resp.Code).To(Equal(503))
+            Expect(healthBody["status"]).To(Equal("fail"))
+            Expect(depStatus("vault")).To(Equal("fail"))
+            Expect(depStatus("consul")).To(Equal("success"))
+        })
+        
+        It("should error when Vault is unreachable", func() {
+            mockVaultClient.On("SealStatus").Return(nil, fmt.Errorf("connection refused"))
+            mockConsulCatalog.
+                On("Service", "consul-wan", "", mock.AnythingOfType("*api.QueryOptions")).
+                Return(consulServers, nil, nil)
+            
+            checkHealth()
+            
+            Expect(resp.Code).To(Equal(503))
+            Expect(healthBody["status"]).To(Equal("error"))
+            Expect(healthBody["message"]).ToNot(BeEmpty())
+            Expect(depStatus("vault")).To(Equal("error"))
+        })
+        
+        It("should error when our token is invalid", func() {
+            mockVaultClient.On("SealStatus").Return(&vaultapi.SealStatusResponse{ Sealed: false }, nil)
+            mockVaultClient.On("LookupSelf").Return(nil, fmt.Errorf("permission denied"))
+            mockConsulCatalog.
+                On("Service", "consul-wan", "", mock.AnythingOfType("*api.QueryOptions")).
+                Return(consulServers, nil, nil)
+            
+            checkHealth()
+            
+            Expect(resp.Code).To(Equal(503))
+            Expect(healthBody["status"]).To(Equal("error"))
+            Expect(depStatus("vault")).To(Equal("error"))
+        })
+        
+        It("should be degraded when our token can't be renewed", func() {
+            mockVaultClient.On("SealStatus").Return(&vaultapi.SealStatusResponse{ Sealed: false }, nil)
+            mockVaultClient.On("LookupSelf").Return(&vaultapi.Secret{}, nil)
+            mockVaultClient.On("RenewalError").Return(fmt.Errorf("permission denied"))
+            mockConsulCatalog.
+                On("Service", "consul-wan", "", mock.AnythingOfType("*api.QueryOptions")).
+                Return(consulServers, nil, nil)
+            
+            checkHealth()
+            
+            Expect(resp.Code).To(Equal(429))
+            Expect(healthBody["status"]).To(Equal("fail"))
+            Expect(depStatus("vault")).To(Equal("fail"))
+            Expect(depStatus("consul")).To(Equal("success"))
+        })
+        
+        It("should error when Consul is unreachable", func() {
+            mockVaultClient.On("SealStatus").Return(&vaultapi.SealStatusResponse{ Sealed: false }, nil)
+            mockVaultClient.On("LookupSelf").Return(&vaultapi.Secret{}, nil)
+            mockVaultClient.On("RenewalError").Return(nil)
+            mockConsulCatalog.
+                On("Service", "consul-wan", "", mock.AnythingOfType("*api.QueryOptions")).
+                Return(nil, nil, fmt.Errorf("connection refused"))
+            
+            checkHealth()
+            
+            Expect(resp.Code).To(Equal(503))
+            Expect(healthBody["status"]).To(Equal("error"))
+            Expect(depStatus("vault")).To(Equal("success"))
+            Expect(depStatus("consul")).To(Equal("error"))
+        })
+        
+        It("should be degraded with no consul-wan servers", func() {
+            mockVaultClient.On("SealStatus").Return(&vaultapi.SealStatusResponse{ Sealed: false }, nil)
+            mockVaultClient.On("LookupSelf").Return(&vaultapi.Secret{}, nil)
+            mockVaultClient.On("RenewalError").Return(nil)
+            mockConsulCatalog.
+                On("Service", "consul-wan", "", mock.AnythingOfType("*api.QueryOptions")).
+                Return([]*consulapi.CatalogService{}, nil, nil)
+            
+            checkHealth()
+            
+            Expect(resp.Code).To(Equal(429))
+            Expect(healthBody["status"]).To(Equal("fail"))
+            Expect(depStatus("consul")).To(Equal("fail"))
         })
     })
 })
@@ -0,0 +1,36 @@
+package main
+
+import (
+    "fmt"
+)
+
+// checks the config without contacting Vault or Consul, so it can be done
+// before rolling the config out
+type ValidateConfigCommand struct {
+    ConfigOptions
+    
+    // the certificates are usually only installed where centralbooking runs
+    SkipCertificates bool `long:"skip-certificates" description:"don't load the AWS identity certificates"`
+}
+
+func (self *ValidateConfigCommand) run() error {
+    _, cfg, awsCerts, err := self.load(self.SkipCertificates)
+    if err != nil {
+        return err
+    }
+    
+    certs := fmt.Sprintf("%d AWS identity certificates", len(awsCerts))
+    if self.SkipCertificates {
+        certs = "AWS identity certificates not checked"
+    }
+    
+    fmt.Printf(
+        "%s: ok (%d environments, %d roles, %s)\n",
+        self.ConfigFile,
+        len(cfg.Environments),
+        len(cfg.Roles),
+        certs,
+    )
+    
+    return nil
+}